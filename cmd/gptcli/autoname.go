@@ -0,0 +1,77 @@
+/* Copyright © 2024 Mike Brown. All Rights Reserved.
+ *
+ * See LICENSE file at the root of this package for license terms
+ */
+package main
+
+import (
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+const (
+	AutoNameMaxWords = 6
+	AutoNameMaxLen   = 40
+	AutoNameFallback = "untitled"
+)
+
+// titleFromPrompt derives a short thread name from a thread's first prompt:
+// the leading words of its first line of prose, ignoring any code blocks.
+func titleFromPrompt(prompt string) string {
+	var line string
+	for idx, block := range splitBlocks(prompt) {
+		if idx%2 == 1 {
+			continue
+		}
+		for _, candidate := range strings.Split(block, "\n") {
+			if strings.TrimSpace(candidate) != "" {
+				line = candidate
+				break
+			}
+		}
+		if line != "" {
+			break
+		}
+	}
+
+	words := strings.Fields(line)
+	if len(words) > AutoNameMaxWords {
+		words = words[:AutoNameMaxWords]
+	}
+	title := ""
+	for _, word := range words {
+		candidate := word
+		if title != "" {
+			candidate = title + " " + word
+		}
+		if utf8.RuneCountInString(candidate) > AutoNameMaxLen {
+			if title == "" {
+				title = string([]rune(word)[:AutoNameMaxLen])
+			}
+			break
+		}
+		title = candidate
+	}
+	title = strings.TrimRightFunc(title, func(r rune) bool {
+		return unicode.IsPunct(r) || unicode.IsSpace(r)
+	})
+	if title == "" {
+		return AutoNameFallback
+	}
+
+	return title
+}
+
+// autoNameThread names a thread that was created without one, after its
+// first exchange, from the prompt that began it.
+func (thrGrp *GptCliThreadGroup) autoNameThread(thread *GptCliThread,
+	prompt string) error {
+
+	name := titleFromPrompt(prompt)
+	if thrGrp.hasThreadName(name) {
+		name = thrGrp.uniqThreadName(name)
+	}
+
+	return thrGrp.renameThread(thread, name)
+}
@@ -0,0 +1,308 @@
+/* Copyright © 2024 Mike Brown. All Rights Reserved.
+ *
+ * See LICENSE file at the root of this package for license terms
+ */
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/sashabaranov/go-openai"
+)
+
+// BatchPromptPrefix marks the start of each prompt's result in batch output.
+const BatchPromptPrefix = ">>> "
+
+// batchEntry is a single prompt read from a batch file.
+type batchEntry struct {
+	// id is an optional user supplied tag echoed in the batch's output so
+	// that replies can be correlated with their prompts
+	id     string
+	prompt string
+}
+
+// String returns the entry in the same form it was read, i.e.
+// "[<id><TAB>]<prompt>".
+func (entry batchEntry) String() string {
+	if entry.id == "" {
+		return entry.prompt
+	}
+
+	return entry.id + "\t" + entry.prompt
+}
+
+// parseBatch reads one prompt per line from r. A prompt may be preceded by an
+// id and a tab, i.e. "<id><TAB><prompt>". Blank lines and lines beginning
+// with '#' are ignored.
+func parseBatch(r io.Reader) ([]batchEntry, error) {
+	entries := make([]batchEntry, 0)
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 0, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		var entry batchEntry
+		id, prompt, found := strings.Cut(line, "\t")
+		if found {
+			entry.id = strings.TrimSpace(id)
+			entry.prompt = strings.TrimSpace(prompt)
+		} else {
+			entry.prompt = line
+		}
+		entries = append(entries, entry)
+	}
+	err := scanner.Err()
+	if err != nil {
+		return nil, fmt.Errorf("Failed to read batch prompts: %w", err)
+	}
+
+	return entries, nil
+}
+
+type batchOpts struct {
+	// continueOnError selects whether to attempt the remaining prompts after
+	// a prompt fails
+	continueOnError bool
+	// checkpointPath, if non-empty, is where progress is recorded so that an
+	// interrupted batch can be resumed
+	checkpointPath string
+}
+
+// batchCheckpoint records how far a batch has progressed.
+type batchCheckpoint struct {
+	// ThreadFile is the batch's thread; it is only recorded once the thread
+	// has been saved, i.e. after the first successful prompt
+	ThreadFile string `json:"thread_file,omitempty"`
+	// Completed is the number of leading entries that have been attempted
+	Completed int `json:"completed"`
+	// Failed holds the indices of attempted entries that failed and should
+	// be retried when the batch is resumed
+	Failed []int `json:"failed,omitempty"`
+}
+
+func loadBatchCheckpoint(checkpointPath string) (batchCheckpoint, error) {
+	var checkpoint batchCheckpoint
+	if checkpointPath == "" {
+		return checkpoint, nil
+	}
+	content, err := os.ReadFile(checkpointPath)
+	if os.IsNotExist(err) {
+		return checkpoint, nil
+	} else if err != nil {
+		return checkpoint, fmt.Errorf("Failed to read batch checkpoint %v: %w",
+			checkpointPath, err)
+	}
+	err = json.Unmarshal(content, &checkpoint)
+	if err != nil {
+		return checkpoint, fmt.Errorf("Failed to parse batch checkpoint %v: %w",
+			checkpointPath, err)
+	}
+	for _, idx := range checkpoint.Failed {
+		if idx < 0 || idx >= checkpoint.Completed {
+			return checkpoint, fmt.Errorf("Batch checkpoint %v records an invalid failed prompt %v\n",
+				checkpointPath, idx)
+		}
+	}
+
+	return checkpoint, nil
+}
+
+func (checkpoint *batchCheckpoint) save(checkpointPath string) error {
+	if checkpointPath == "" {
+		return nil
+	}
+	content, err := json.Marshal(checkpoint)
+	if err != nil {
+		return fmt.Errorf("Failed to marshal batch checkpoint: %w", err)
+	}
+	err = os.WriteFile(checkpointPath, content, 0600)
+	if err != nil {
+		return fmt.Errorf("Failed to save batch checkpoint %v: %w",
+			checkpointPath, err)
+	}
+
+	return nil
+}
+
+// batchThread returns the thread a batch's prompts are sent within: the
+// thread recorded in checkpoint when resuming, otherwise a new thread named
+// name.
+func (gptCliCtx *GptCliContext) batchThread(name string,
+	checkpoint batchCheckpoint) *GptCliThread {
+
+	thrGrp := gptCliCtx.mainThreadGroup
+	if checkpoint.ThreadFile != "" {
+		for _, thread := range thrGrp.threads {
+			if thread.fileName == checkpoint.ThreadFile {
+				return thread
+			}
+		}
+	}
+
+	cTime := time.Now()
+	thread := &GptCliThread{
+		Name:       name,
+		CreateTime: cTime,
+		AccessTime: cTime,
+		ModTime:    cTime,
+		Dialogue: []openai.ChatCompletionMessage{
+			{Role: openai.ChatMessageRoleSystem,
+				Content: gptCliCtx.prefs.systemMsg()},
+		},
+		SummaryDialogue: make([]openai.ChatCompletionMessage, 0),
+		fileName:        genUniqFileName(name, cTime),
+	}
+	thrGrp.addThread(thread)
+
+	return thread
+}
+
+// runBatch sends each entry's prompt in turn within a thread named name,
+// writing each prompt and its reply to w. Unless opts.continueOnError is set
+// the batch stops at the first failed prompt; otherwise failures are
+// recorded in the output and reported once all prompts have been attempted.
+// When opts.checkpointPath is set, a previous run's failed prompts are
+// retried, the entries it completed are skipped, and the checkpoint is
+// removed once every prompt has succeeded.
+func (gptCliCtx *GptCliContext) runBatch(ctx context.Context,
+	entries []batchEntry, name string, w io.Writer, opts batchOpts) error {
+
+	if gptCliCtx.needConfig {
+		return fmt.Errorf("You must run 'config' before running a batch.\n")
+	}
+
+	checkpoint, err := loadBatchCheckpoint(opts.checkpointPath)
+	if err != nil {
+		return err
+	}
+	if checkpoint.Completed > len(entries) {
+		return fmt.Errorf("Batch checkpoint %v records %v completed prompts but the batch only has %v\n",
+			opts.checkpointPath, checkpoint.Completed, len(entries))
+	}
+	thread := gptCliCtx.batchThread(name, checkpoint)
+
+	numFailed := 0
+	retries := slices.Clone(checkpoint.Failed)
+	for idx := range entries {
+		isRetry := slices.Contains(retries, idx)
+		if idx < checkpoint.Completed && !isRetry {
+			continue
+		}
+
+		err = gptCliCtx.sendBatchEntry(ctx, thread, w, entries[idx])
+		if err != nil && !opts.continueOnError {
+			return fmt.Errorf("Batch prompt %q failed: %w", entries[idx], err)
+		}
+		if err != nil {
+			numFailed++
+			if !isRetry {
+				checkpoint.Failed = append(checkpoint.Failed, idx)
+			}
+		} else {
+			checkpoint.ThreadFile = thread.fileName
+			if isRetry {
+				checkpoint.Failed = slices.DeleteFunc(checkpoint.Failed,
+					func(i int) bool { return i == idx })
+			}
+		}
+		checkpoint.Completed = max(checkpoint.Completed, idx+1)
+		err = checkpoint.save(opts.checkpointPath)
+		if err != nil {
+			return err
+		}
+	}
+
+	if numFailed != 0 {
+		return fmt.Errorf("%v of %v batch prompts failed\n", numFailed,
+			len(entries))
+	}
+	if opts.checkpointPath != "" {
+		_ = os.Remove(opts.checkpointPath)
+	}
+
+	return nil
+}
+
+// sendBatchEntry sends entry's prompt within thread and writes the prompt
+// along with its reply, or the reason it failed, to w.
+func (gptCliCtx *GptCliContext) sendBatchEntry(ctx context.Context,
+	thread *GptCliThread, w io.Writer, entry batchEntry) error {
+
+	fmt.Fprintf(w, "%v%v\n", BatchPromptPrefix, entry)
+	reply, err := gptCliCtx.sendBatchPrompt(ctx, thread,
+		gptCliCtx.mainThreadGroup.dir, entry.prompt)
+	if err != nil {
+		fmt.Fprintf(w, "*ERROR*: %v\n\n", strings.TrimSpace(err.Error()))
+		return err
+	}
+	fmt.Fprintf(w, "%v\n\n", strings.TrimRight(reply, "\n"))
+
+	return nil
+}
+
+// sendBatchPrompt applies the same checks as interactive prompts but, as
+// there is no user to confirm with, refuses rather than asks.
+func (gptCliCtx *GptCliContext) sendBatchPrompt(ctx context.Context,
+	thread *GptCliThread, dir string, prompt string) (string, error) {
+
+	budget := gptCliCtx.prefs.SessionBudgetTokens
+	if budget > 0 && gptCliCtx.sessionTokens >= budget {
+		return "", fmt.Errorf("Session token budget of %v exceeded\n", budget)
+	}
+	maxBytes := gptCliCtx.prefs.MaxPromptBytes
+	if maxBytes > 0 && len(prompt) > maxBytes {
+		return "", fmt.Errorf("Prompt is %v bytes, exceeding the maximum of %v\n",
+			len(prompt), maxBytes)
+	}
+
+	return sendPrompt(ctx, gptCliCtx, thread, dir, prompt, requestChat)
+}
+
+// batchMain runs the prompts in the file at batchPath, writing results to
+// outPath or to stdout if outPath is empty. Output from a resumed batch is
+// appended to outPath.
+func batchMain(ctx context.Context, gptCliCtx *GptCliContext,
+	batchPath string, outPath string, opts batchOpts) error {
+
+	f, err := os.Open(batchPath)
+	if err != nil {
+		return fmt.Errorf("Failed to open batch file %v: %w", batchPath, err)
+	}
+	defer f.Close()
+	entries, err := parseBatch(f)
+	if err != nil {
+		return err
+	}
+
+	var w io.Writer = os.Stdout
+	if outPath != "" {
+		flags := os.O_WRONLY | os.O_CREATE | os.O_TRUNC
+		if opts.checkpointPath != "" {
+			_, err := os.Stat(opts.checkpointPath)
+			if err == nil {
+				flags = os.O_WRONLY | os.O_CREATE | os.O_APPEND
+			}
+		}
+		outFile, err := os.OpenFile(outPath, flags, 0600)
+		if err != nil {
+			return fmt.Errorf("Failed to create batch output %v: %w", outPath,
+				err)
+		}
+		defer outFile.Close()
+		w = outFile
+	}
+
+	name := fmt.Sprintf("batch %v", filepath.Base(batchPath))
+	return gptCliCtx.runBatch(ctx, entries, name, w, opts)
+}
@@ -0,0 +1,66 @@
+/* Copyright © 2024 Mike Brown. All Rights Reserved.
+ *
+ * See LICENSE file at the root of this package for license terms
+ */
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/sashabaranov/go-openai"
+)
+
+const DefaultBudgetWarnPercent = 80
+
+// budgetWarnPercent returns the percentage of the session budget at which
+// the user is warned.
+func (prefs *Prefs) budgetWarnPercent() int {
+	if prefs.BudgetWarnPercent <= 0 || prefs.BudgetWarnPercent > 100 {
+		return DefaultBudgetWarnPercent
+	}
+
+	return prefs.BudgetWarnPercent
+}
+
+// accrueUsage adds usage to the tokens consumed by this session, warning the
+// user the first time the session crosses the configured warning threshold.
+// It returns true if a warning was issued.
+func (gptCliCtx *GptCliContext) accrueUsage(usage openai.Usage) bool {
+	gptCliCtx.sessionTokens += usage.TotalTokens
+
+	budget := gptCliCtx.prefs.SessionBudgetTokens
+	if budget <= 0 || gptCliCtx.budgetWarned {
+		return false
+	}
+	pct := gptCliCtx.prefs.budgetWarnPercent()
+	if gptCliCtx.sessionTokens*100 < budget*pct {
+		return false
+	}
+
+	gptCliCtx.budgetWarned = true
+	fmt.Printf("gptcli: *WARN*: This session has used %v of its %v token budget (%v%%).\n",
+		gptCliCtx.sessionTokens, budget,
+		gptCliCtx.sessionTokens*100/budget)
+
+	return true
+}
+
+// checkBudget returns true if a new prompt may be sent. Once the session has
+// exhausted its token budget the user must confirm each further prompt.
+func checkBudget(gptCliCtx *GptCliContext) (bool, error) {
+	budget := gptCliCtx.prefs.SessionBudgetTokens
+	if budget <= 0 || gptCliCtx.sessionTokens < budget {
+		return true, nil
+	}
+
+	fmt.Printf("This session has used %v tokens, exceeding its budget of %v. Send anyway? (Y/N) [N]: ",
+		gptCliCtx.sessionTokens, budget)
+	answer, err := gptCliCtx.input.ReadString('\n')
+	if err != nil {
+		return false, err
+	}
+	answer = strings.ToUpper(strings.TrimSpace(answer))
+
+	return len(answer) > 0 && answer[0] == 'Y', nil
+}
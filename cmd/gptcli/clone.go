@@ -0,0 +1,117 @@
+/* Copyright © 2024 Mike Brown. All Rights Reserved.
+ *
+ * See LICENSE file at the root of this package for license terms
+ */
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/sashabaranov/go-openai"
+)
+
+// cloneMessage returns a deep copy of msg which shares no memory with it.
+func cloneMessage(msg openai.ChatCompletionMessage) openai.ChatCompletionMessage {
+	clone := msg
+	if msg.MultiContent != nil {
+		clone.MultiContent = make([]openai.ChatMessagePart, len(msg.MultiContent))
+		for idx, part := range msg.MultiContent {
+			if part.ImageURL != nil {
+				imageURL := *part.ImageURL
+				part.ImageURL = &imageURL
+			}
+			clone.MultiContent[idx] = part
+		}
+	}
+	if msg.FunctionCall != nil {
+		functionCall := *msg.FunctionCall
+		clone.FunctionCall = &functionCall
+	}
+	if msg.ToolCalls != nil {
+		clone.ToolCalls = make([]openai.ToolCall, len(msg.ToolCalls))
+		for idx, toolCall := range msg.ToolCalls {
+			if toolCall.Index != nil {
+				index := *toolCall.Index
+				toolCall.Index = &index
+			}
+			clone.ToolCalls[idx] = toolCall
+		}
+	}
+
+	return clone
+}
+
+func cloneDialogue(
+	dialogue []openai.ChatCompletionMessage) []openai.ChatCompletionMessage {
+
+	clone := make([]openai.ChatCompletionMessage, 0, len(dialogue))
+	for _, msg := range dialogue {
+		clone = append(clone, cloneMessage(msg))
+	}
+
+	return clone
+}
+
+// clone returns a new thread named name with a copy of thread's dialogue,
+// linked back to thread as its parent.
+func (thread *GptCliThread) clone(name string) *GptCliThread {
+	cTime := time.Now()
+
+	return &GptCliThread{
+		Name:            name,
+		CreateTime:      cTime,
+		AccessTime:      cTime,
+		ModTime:         cTime,
+		Dialogue:        cloneDialogue(thread.Dialogue),
+		SummaryDialogue: cloneDialogue(thread.SummaryDialogue),
+		Repo:            thread.Repo,
+		RepoPath:        thread.RepoPath,
+		Parent:          thread.id(),
+		fileName:        genUniqFileName(name, cTime),
+	}
+}
+
+func cloneThreadMain(ctx context.Context, gptCliCtx *GptCliContext,
+	args []string) error {
+
+	if len(args) != 2 {
+		return fmt.Errorf("Syntax is 'clone <thread#>' e.g. 'clone 1'\n")
+	}
+	thrGrp, threadNum, err := parseThreadNum(gptCliCtx, args[1])
+	if err != nil {
+		return err
+	}
+	if threadNum > thrGrp.totThreads || threadNum == 0 {
+		return fmt.Errorf(ThreadNoExistErrFmt, args[1])
+	}
+	src := thrGrp.threads[threadNum-1]
+
+	defaultName := fmt.Sprintf("%v (clone)", src.Name)
+	fmt.Printf("Enter cloned thread's name [%v]: ", defaultName)
+	name, err := gptCliCtx.input.ReadString('\n')
+	if err != nil {
+		return err
+	}
+	name = strings.TrimSpace(name)
+	if name == "" {
+		name = defaultName
+	}
+	mainThrGrp := gptCliCtx.mainThreadGroup
+	name, err = confirmThreadName(gptCliCtx, mainThrGrp, name)
+	if err != nil {
+		return err
+	}
+
+	clone := src.clone(name)
+	err = clone.save(mainThrGrp.dir)
+	if err != nil {
+		return err
+	}
+	cloneNum := mainThrGrp.addThread(clone)
+	fmt.Printf("gptcli: Cloned thread %v to thread %v.\n", args[1], cloneNum)
+
+	return nil
+}
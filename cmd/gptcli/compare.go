@@ -0,0 +1,143 @@
+/* Copyright © 2024 Mike Brown. All Rights Reserved.
+ *
+ * See LICENSE file at the root of this package for license terms
+ */
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"strings"
+
+	"github.com/sashabaranov/go-openai"
+)
+
+// compareModelList returns the models a prompt should be compared across:
+// those given on the command line, else the user's configured list, else
+// every supported model. Aliases are resolved.
+func (prefs *Prefs) compareModelList(modelsArg string) ([]string, error) {
+	names := prefs.CompareModels
+	if modelsArg != "" {
+		names = strings.Split(modelsArg, ",")
+	}
+	if len(names) == 0 {
+		names = SupportedModels
+	}
+
+	models := make([]string, 0, len(names))
+	for _, name := range names {
+		name = strings.TrimSpace(name)
+		if name == "" {
+			continue
+		}
+		model, err := resolveModel(prefs.ModelAliases, name)
+		if err != nil {
+			return nil, err
+		}
+		models = append(models, model)
+	}
+
+	return models, nil
+}
+
+// compareModels sends the same dialogue ending in prompt to each model in
+// turn, returning each model's reply. dialogue is the context the prompt is
+// sent within; neither it nor the user's model preference are modified.
+func compareModels(ctx context.Context, gptCliCtx *GptCliContext,
+	dialogue []openai.ChatCompletionMessage, prompt string,
+	models []string) ([]string, error) {
+
+	dialogue = append(append([]openai.ChatCompletionMessage{}, dialogue...),
+		openai.ChatCompletionMessage{
+			Role:    openai.ChatMessageRoleUser,
+			Content: prompt,
+		})
+	dialogue, err := expandSystemMsgs(dialogue)
+	if err != nil {
+		return nil, err
+	}
+	dialogue = applyUserPreamble(dialogue, &gptCliCtx.prefs)
+
+	replies := make([]string, 0, len(models))
+	for _, model := range models {
+		req := openai.ChatCompletionRequest{
+			Model:    model,
+			Messages: dialogue,
+		}
+		reply, err := requestChat(ctx, gptCliCtx, req)
+		if err != nil {
+			return nil, fmt.Errorf("Failed to get reply from %v: %w", model, err)
+		}
+		replies = append(replies, reply)
+	}
+
+	return replies, nil
+}
+
+func compareMain(ctx context.Context, gptCliCtx *GptCliContext,
+	args []string) error {
+
+	if gptCliCtx.needConfig {
+		return fmt.Errorf("You must run 'config' before comparing models.\n")
+	}
+
+	var modelsArg string
+	f := flag.NewFlagSet("compare", flag.ContinueOnError)
+	f.StringVar(&modelsArg, "models", "",
+		"Comma separated list of models or aliases to compare")
+	err := f.Parse(args[1:])
+	if err != nil {
+		return err
+	}
+	prompt := strings.TrimSpace(strings.Join(f.Args(), " "))
+	if prompt == "" {
+		return fmt.Errorf("Syntax is 'compare [--models <m1,m2>] <prompt>' e.g. 'compare --models gpt-4o,gpt-4o-mini why is the sky blue?'\n")
+	}
+	models, err := gptCliCtx.prefs.compareModelList(modelsArg)
+	if err != nil {
+		return err
+	}
+
+	shouldSend, err := checkBudget(gptCliCtx)
+	if err != nil {
+		return err
+	} else if !shouldSend {
+		fmt.Printf("gptcli: Prompt not sent.\n")
+		return nil
+	}
+	// the same prompt is sent to every model so one check covers them all
+	prompt, shouldSend, err = checkPromptSize(gptCliCtx, prompt)
+	if err != nil {
+		return err
+	} else if !shouldSend {
+		fmt.Printf("gptcli: Prompt not sent.\n")
+		return nil
+	}
+
+	// compare within the current thread's context, if any, without adding
+	// the replies to it
+	dialogue := []openai.ChatCompletionMessage{
+		{Role: openai.ChatMessageRoleSystem, Content: gptCliCtx.prefs.systemMsg()},
+	}
+	thrGrp := gptCliCtx.curThreadGroup
+	if thrGrp.curThreadNum != 0 {
+		dialogue = thrGrp.threads[thrGrp.curThreadNum-1].Dialogue
+	}
+
+	logInfo("gptcli: comparing %v models...\n", len(models))
+	replies, err := compareModels(ctx, gptCliCtx, dialogue, prompt, models)
+	if err != nil {
+		return err
+	}
+
+	var sb strings.Builder
+	for idx, model := range models {
+		sb.WriteString(fmt.Sprintf("=== %v ===\n", model))
+		sb.WriteString(formatReply(replies[idx]))
+		sb.WriteString("\n")
+	}
+	printToScreen(sb.String())
+
+	return nil
+}
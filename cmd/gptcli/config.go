@@ -9,12 +9,13 @@ import (
 	_ "embed"
 	"encoding/json"
 	"fmt"
+	"io"
 	"os"
 	"path"
 	"path/filepath"
+	"strconv"
 	"strings"
-
-	"github.com/sashabaranov/go-openai"
+	"time"
 )
 
 func (gptCliCtx *GptCliContext) loadPrefs() error {
@@ -35,10 +36,97 @@ func (gptCliCtx *GptCliContext) loadPrefs() error {
 		return err
 	}
 	gptCliCtx.curSummaryToggle = gptCliCtx.prefs.SummarizePrior
+	gptCliCtx.prefs.sanitize()
 
 	return nil
 }
 
+// sanitize replaces any invalid user supplied preferences with their defaults,
+// warning the user about each one, and resolves derived state such as the
+// display time zone.
+func (prefs *Prefs) sanitize() {
+	err := validateDateFormat(prefs.DateFormat)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "*WARN*: %v; using default\n", err)
+		prefs.DateFormat = ""
+	}
+
+	if prefs.StreamFlushMs < 0 || prefs.StreamFlushMs > MaxStreamFlushMs {
+		fmt.Fprintf(os.Stderr, "*WARN*: Invalid stream flush interval %vms; must be between 0 and %vms\n",
+			prefs.StreamFlushMs, MaxStreamFlushMs)
+		prefs.StreamFlushMs = 0
+	}
+
+	switch prefs.TrimMode {
+	case "", TrimModeFull, TrimModeEdges, TrimModeNone:
+	default:
+		fmt.Fprintf(os.Stderr, "*WARN*: Invalid trim mode %q; must be one of %v, %v, or %v\n",
+			prefs.TrimMode, TrimModeFull, TrimModeEdges, TrimModeNone)
+		prefs.TrimMode = ""
+	}
+
+	switch prefs.PreamblePlacement {
+	case "", PreamblePrepend, PreambleAppend:
+	default:
+		fmt.Fprintf(os.Stderr, "*WARN*: Invalid preamble placement %q; must be %v or %v\n",
+			prefs.PreamblePlacement, PreamblePrepend, PreambleAppend)
+		prefs.PreamblePlacement = ""
+	}
+
+	switch prefs.SummaryStyle {
+	case "", SummaryStyleShort, SummaryStyleDetailed, SummaryStyleBullet:
+	default:
+		fmt.Fprintf(os.Stderr, "*WARN*: Invalid summary style %q; must be one of %v, %v, or %v\n",
+			prefs.SummaryStyle, SummaryStyleShort, SummaryStyleDetailed,
+			SummaryStyleBullet)
+		prefs.SummaryStyle = ""
+	}
+	if prefs.SummaryMaxWords < 0 {
+		fmt.Fprintf(os.Stderr, "*WARN*: Invalid summary length %v; must not be negative\n",
+			prefs.SummaryMaxWords)
+		prefs.SummaryMaxWords = 0
+	}
+
+	_, ok := threadSortDescs[prefs.threadSort()]
+	if !ok {
+		fmt.Fprintf(os.Stderr, "*WARN*: Invalid thread sort %q; must be one of %v\n",
+			prefs.ThreadSort, strings.Join(threadSortModes, ", "))
+		prefs.ThreadSort = ""
+	}
+
+	if prefs.IdleExitMins < 0 {
+		fmt.Fprintf(os.Stderr, "*WARN*: Invalid idle exit timeout %v minutes; must not be negative\n",
+			prefs.IdleExitMins)
+		prefs.IdleExitMins = 0
+	}
+
+	prefs.timeLoc = time.Local
+	if prefs.TimeZone != "" {
+		loc, err := time.LoadLocation(prefs.TimeZone)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "*WARN*: Invalid time zone %q: %v; using local time\n",
+				prefs.TimeZone, err)
+		} else {
+			prefs.timeLoc = loc
+		}
+	}
+}
+
+// streamFlushInterval returns the minimum interval between writes of a
+// streamed reply to the terminal.
+func (prefs *Prefs) streamFlushInterval() time.Duration {
+	return time.Duration(prefs.StreamFlushMs) * time.Millisecond
+}
+
+// location returns the time zone that timestamps should be displayed in.
+func (prefs *Prefs) location() *time.Location {
+	if prefs.timeLoc == nil {
+		return time.Local
+	}
+
+	return prefs.timeLoc
+}
+
 func (gptCliCtx *GptCliContext) savePrefs() error {
 	prefsFileContent, err := json.Marshal(gptCliCtx.prefs)
 	if err != nil {
@@ -88,15 +176,33 @@ func configMain(ctx context.Context, gptCliCtx *GptCliContext, args []string) er
 	if err != nil && !os.IsNotExist(err) {
 		return fmt.Errorf("Could not open OpenAI API key file %v: %w", keyPath, err)
 	}
-	fmt.Printf("Enter your OpenAI API key: ")
-	key, err := gptCliCtx.input.ReadString('\n')
-	if err != nil {
-		return err
+	replaceKey := true
+	if err == nil {
+		fmt.Printf("An OpenAI API key is already configured. Replace it? (Y/N) [N]: ")
+		shouldReplace, err := gptCliCtx.input.ReadString('\n')
+		if err != nil {
+			return err
+		}
+		shouldReplace = strings.ToUpper(strings.TrimSpace(shouldReplace))
+		replaceKey = len(shouldReplace) > 0 && shouldReplace[0] == 'Y'
 	}
-	key = strings.TrimSpace(key)
-	err = os.WriteFile(keyPath, []byte(key), 0600)
-	if err != nil {
-		return fmt.Errorf("Could not write OpenAI API key file %v: %w", keyPath, err)
+	var key string
+	if replaceKey {
+		fmt.Printf("Enter your OpenAI API key: ")
+		key, err = gptCliCtx.input.ReadString('\n')
+		if err != nil {
+			return err
+		}
+		key = strings.TrimSpace(key)
+		err = os.WriteFile(keyPath, []byte(key), 0600)
+		if err != nil {
+			return fmt.Errorf("Could not write OpenAI API key file %v: %w", keyPath, err)
+		}
+	} else {
+		key, err = loadKey()
+		if err != nil {
+			return err
+		}
 	}
 	threadsPath := path.Join(configDir, ThreadsDir)
 	err = os.MkdirAll(threadsPath, 0700)
@@ -111,9 +217,21 @@ func configMain(ctx context.Context, gptCliCtx *GptCliContext, args []string) er
 			archivePath, err)
 	}
 
-	gptCliCtx.client = openai.NewClient(key)
+	gptCliCtx.client = gptCliCtx.newClient(key)
 	gptCliCtx.needConfig = false
 
+	_, err = gptCliCtx.client.ListModels(ctx)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "*WARN*: Unable to validate OpenAI API key: %v\n",
+			err)
+	}
+
+	model, err := selectModel(gptCliCtx)
+	if err != nil {
+		return err
+	}
+	gptCliCtx.prefs.Model = model
+
 	fmt.Printf("Summarize dialogue when continuing threads? (reduces costs for less precise replies from OpenAI) [N]: ")
 	shouldSummarize, err := gptCliCtx.input.ReadString('\n')
 	if err != nil {
@@ -127,16 +245,114 @@ func configMain(ctx context.Context, gptCliCtx *GptCliContext, args []string) er
 	gptCliCtx.prefs.SummarizePrior = (shouldSummarize[0] == 'Y')
 	gptCliCtx.curSummaryToggle = gptCliCtx.prefs.SummarizePrior
 
+	defaultDateFormat := gptCliCtx.prefs.DateFormat
+	if defaultDateFormat == "" {
+		defaultDateFormat = HeaderTimeFmt
+	}
+	fmt.Printf("Date format for thread listings (Go time layout) [%v]: ",
+		defaultDateFormat)
+	dateFormat, err := gptCliCtx.input.ReadString('\n')
+	if err != nil {
+		return err
+	}
+	dateFormat = strings.TrimSpace(dateFormat)
+	if dateFormat == "" {
+		dateFormat = defaultDateFormat
+	}
+	if dateFormat == HeaderTimeFmt {
+		dateFormat = ""
+	}
+	err = validateDateFormat(dateFormat)
+	if err != nil {
+		return err
+	}
+	gptCliCtx.prefs.DateFormat = dateFormat
+
 	return gptCliCtx.savePrefs()
 }
 
+// selectModel prompts the user to choose one of SupportedModels, defaulting
+// to the currently configured model.
+func selectModel(gptCliCtx *GptCliContext) (string, error) {
+	defaultIdx := 0
+	fmt.Printf("Available models:\n")
+	for idx, model := range SupportedModels {
+		if model == gptCliCtx.prefs.model() {
+			defaultIdx = idx
+		}
+		fmt.Printf("  %v) %v\n", idx+1, model)
+	}
+	fmt.Printf("Select a model [%v]: ", defaultIdx+1)
+	selection, err := gptCliCtx.input.ReadString('\n')
+	if err != nil {
+		return "", err
+	}
+
+	selection = strings.TrimSpace(selection)
+	if len(selection) == 0 {
+		return SupportedModels[defaultIdx], nil
+	}
+	modelNum, err := strconv.Atoi(selection)
+	if err != nil || modelNum < 1 || modelNum > len(SupportedModels) {
+		return "", fmt.Errorf("Invalid model selection %v; please enter a number between 1 and %v\n",
+			selection, len(SupportedModels))
+	}
+
+	return SupportedModels[modelNum-1], nil
+}
+
+// systemMsg returns the system message used for new threads.
+func (prefs *Prefs) systemMsg() string {
+	if prefs.SystemMsg == "" {
+		return SystemMsg
+	}
+
+	return prefs.SystemMsg
+}
+
+// model returns the model used for thread conversations.
+func (prefs *Prefs) model() string {
+	if prefs.Model == "" {
+		return DefaultModel
+	}
+
+	return prefs.Model
+}
+
+// validateDateFormat checks that layout is a usable Go time layout, i.e. that
+// it contains at least one date or time element and that a timestamp rendered
+// with it can be parsed back. An empty layout selects the default.
+func validateDateFormat(layout string) error {
+	if layout == "" {
+		return nil
+	}
+
+	refTime := time.Date(2024, time.November, 23, 21, 37, 48, 0, time.UTC)
+	rendered := refTime.Format(layout)
+	if rendered == layout {
+		return fmt.Errorf("Invalid date format %q: no date or time elements",
+			layout)
+	}
+	_, err := time.Parse(layout, rendered)
+	if err != nil {
+		return fmt.Errorf("Invalid date format %q: %w", layout, err)
+	}
+
+	return nil
+}
+
 func getConfigDir() (string, error) {
 	homeDir, err := os.UserHomeDir()
 	if err != nil {
 		return "", fmt.Errorf("Could not find user home directory: %w", err)
 	}
 
-	return filepath.Join(homeDir, ".config", CommandName), nil
+	configDir := filepath.Join(homeDir, ".config", CommandName)
+	if curProfile != "" {
+		configDir = filepath.Join(configDir, ProfilesDir, curProfile)
+	}
+
+	return configDir, nil
 }
 
 func getKeyPath() (string, error) {
@@ -163,6 +379,14 @@ func getThreadsDir() (string, error) {
 	return filepath.Join(configDir, ThreadsDir), nil
 }
 
+func getPromptsDir() (string, error) {
+	configDir, err := getConfigDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(configDir, PromptsDir), nil
+}
+
 func getArchiveDir() (string, error) {
 	configDir, err := getConfigDir()
 	if err != nil {
@@ -171,6 +395,32 @@ func getArchiveDir() (string, error) {
 	return filepath.Join(configDir, ArchiveDir), nil
 }
 
+// printConfigPaths writes the resolved locations of gptcli's data files for
+// the active profile to w.
+func printConfigPaths(w io.Writer) error {
+	resolvers := []struct {
+		desc     string
+		resolver func() (string, error)
+	}{
+		{"config", getConfigDir},
+		{"key", getKeyPath},
+		{"prefs", getPrefsPath},
+		{"threads", getThreadsDir},
+		{"archive", getArchiveDir},
+		{"prompts", getPromptsDir},
+	}
+
+	for _, r := range resolvers {
+		p, err := r.resolver()
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(w, "%-8v %v\n", r.desc+":", p)
+	}
+
+	return nil
+}
+
 func loadKey() (string, error) {
 	keyPath, err := getKeyPath()
 	if err != nil {
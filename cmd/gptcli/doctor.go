@@ -0,0 +1,194 @@
+/* Copyright © 2024 Mike Brown. All Rights Reserved.
+ *
+ * See LICENSE file at the root of this package for license terms
+ */
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+
+	"golang.org/x/term"
+)
+
+type doctorCheck struct {
+	name  string
+	check func() error
+}
+
+func checkTTY(isTerminal func(fd int) bool) error {
+	if !isTerminal(int(os.Stdin.Fd())) {
+		return fmt.Errorf("stdin is not a terminal; gptcli is interactive and expects to be run from a terminal")
+	}
+	if !isTerminal(int(os.Stdout.Fd())) {
+		return fmt.Errorf("stdout is not a terminal; replies will not be streamed or colored")
+	}
+
+	return nil
+}
+
+func checkColors(getenv func(key string) string) error {
+	if getenv("NO_COLOR") != "" {
+		return fmt.Errorf("NO_COLOR is set; unset it to enable colored replies")
+	}
+	termName := getenv("TERM")
+	if termName == "" || termName == "dumb" {
+		return fmt.Errorf("TERM is %q; set TERM to your terminal type (e.g. xterm-256color) to enable colored replies",
+			termName)
+	}
+
+	return nil
+}
+
+func checkUTF8Locale(getenv func(key string) string) error {
+	// LC_ALL overrides LC_CTYPE which overrides LANG
+	for _, key := range []string{"LC_ALL", "LC_CTYPE", "LANG"} {
+		locale := getenv(key)
+		if locale == "" {
+			continue
+		}
+		normalized := strings.ToLower(strings.ReplaceAll(locale, "-", ""))
+		if strings.Contains(normalized, "utf8") {
+			return nil
+		}
+		return fmt.Errorf("%v=%v is not a UTF-8 locale; set e.g. LANG=en_US.UTF-8 so multibyte characters display correctly",
+			key, locale)
+	}
+
+	return fmt.Errorf("no locale is set; set e.g. LANG=en_US.UTF-8 so multibyte characters display correctly")
+}
+
+func checkKey(keyPath string) error {
+	data, err := os.ReadFile(keyPath)
+	if os.IsNotExist(err) {
+		return fmt.Errorf("no OpenAI API key found at %v; run 'config' to set one", keyPath)
+	} else if err != nil {
+		return fmt.Errorf("unable to read OpenAI API key %v: %v; check its permissions", keyPath, err)
+	}
+	if strings.TrimSpace(string(data)) == "" {
+		return fmt.Errorf("OpenAI API key %v is empty; run 'config' to set one", keyPath)
+	}
+
+	return nil
+}
+
+func checkDir(dir string) error {
+	fi, err := os.Stat(dir)
+	if os.IsNotExist(err) {
+		return fmt.Errorf("%v does not exist; run 'config' to create it", dir)
+	} else if err != nil {
+		return fmt.Errorf("unable to access %v: %v", dir, err)
+	}
+	if !fi.IsDir() {
+		return fmt.Errorf("%v is not a directory; move it aside and run 'config'", dir)
+	}
+
+	probe, err := os.CreateTemp(dir, ".gptcli.doctor.*")
+	if err != nil {
+		return fmt.Errorf("%v is not writable: %v; fix with 'chmod u+rwx %v'", dir, err, dir)
+	}
+	probe.Close()
+	_ = os.Remove(probe.Name())
+	if fi.Mode().Perm()&0077 != 0 {
+		return fmt.Errorf("%v is accessible by other users (mode %v); restrict it with 'chmod 700 %v'",
+			dir, fi.Mode().Perm(), dir)
+	}
+
+	return nil
+}
+
+func checkExecutable(lookPath func(file string) (string, error), name string,
+	purpose string) error {
+
+	_, err := lookPath(name)
+	if err != nil {
+		return fmt.Errorf("%v was not found in $PATH; install it for %v", name,
+			purpose)
+	}
+
+	return nil
+}
+
+func checkEditor(getenv func(key string) string,
+	lookPath func(file string) (string, error)) error {
+
+	editor := strings.Fields(getenv("EDITOR"))
+	if len(editor) == 0 {
+		return fmt.Errorf("$EDITOR is not set; set it to use the 'edit' command e.g. 'export EDITOR=vim'")
+	}
+
+	return checkExecutable(lookPath, editor[0], "the 'edit' command")
+}
+
+func doctorChecks() []doctorCheck {
+	checks := []doctorCheck{
+		{"terminal", func() error { return checkTTY(term.IsTerminal) }},
+		{"colors", func() error { return checkColors(os.Getenv) }},
+		{"locale", func() error { return checkUTF8Locale(os.Getenv) }},
+		{"api key", func() error {
+			keyPath, err := getKeyPath()
+			if err != nil {
+				return err
+			}
+			return checkKey(keyPath)
+		}},
+	}
+
+	for _, dirFunc := range []func() (string, error){getConfigDir,
+		getThreadsDir, getArchiveDir} {
+
+		dir, err := dirFunc()
+		checks = append(checks, doctorCheck{
+			name: "directory " + filepath.Base(dir),
+			check: func() error {
+				if err != nil {
+					return err
+				}
+				return checkDir(dir)
+			},
+		})
+	}
+
+	checks = append(checks,
+		doctorCheck{"git", func() error {
+			return checkExecutable(exec.LookPath, "git",
+				"repository context on new threads")
+		}},
+		doctorCheck{"pager", func() error {
+			return checkExecutable(exec.LookPath, "less",
+				"paging long threads and replies")
+		}},
+		doctorCheck{"editor", func() error {
+			return checkEditor(os.Getenv, exec.LookPath)
+		}},
+	)
+
+	return checks
+}
+
+func doctorMain(ctx context.Context, gptCliCtx *GptCliContext,
+	args []string) error {
+
+	failures := 0
+	for _, c := range doctorChecks() {
+		err := c.check()
+		if err != nil {
+			failures++
+			fmt.Printf("[FAIL] %v: %v\n", c.name, err)
+		} else {
+			fmt.Printf("[ OK ] %v\n", c.name)
+		}
+	}
+
+	if failures == 0 {
+		fmt.Printf("gptcli: No problems found.\n")
+	} else {
+		fmt.Printf("gptcli: Found %v problem(s).\n", failures)
+	}
+
+	return nil
+}
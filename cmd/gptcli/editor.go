@@ -0,0 +1,88 @@
+/* Copyright © 2024 Mike Brown. All Rights Reserved.
+ *
+ * See LICENSE file at the root of this package for license terms
+ */
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+)
+
+// editorRunner runs the user's editor (a command line such as "vim" or
+// "code -w") on the file at path, returning once the editor exits.
+type editorRunner func(editor string, path string) error
+
+func runEditor(editor string, path string) error {
+	editorArgs := strings.Fields(editor)
+	editorArgs = append(editorArgs, path)
+	cmd := exec.Command(editorArgs[0], editorArgs[1:]...)
+	cmd.Stdin = os.Stdin
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+
+	return cmd.Run()
+}
+
+// editPrompt opens $EDITOR on a temporary file seeded with seed and returns
+// the file's contents once the editor exits successfully.
+func editPrompt(gptCliCtx *GptCliContext, seed string) (string, error) {
+	editor := strings.TrimSpace(os.Getenv("EDITOR"))
+	if editor == "" {
+		return "", fmt.Errorf("$EDITOR is not set; set it to your preferred editor e.g. 'export EDITOR=vim'\n")
+	}
+
+	tmpFile, err := os.CreateTemp("", "gptcli.prompt.*.md")
+	if err != nil {
+		return "", fmt.Errorf("Failed to create prompt file: %w", err)
+	}
+	defer os.Remove(tmpFile.Name())
+	_, err = tmpFile.WriteString(seed)
+	tmpFile.Close()
+	if err != nil {
+		return "", fmt.Errorf("Failed to write prompt file %v: %w", tmpFile.Name(),
+			err)
+	}
+
+	err = gptCliCtx.editor(editor, tmpFile.Name())
+	if err != nil {
+		return "", fmt.Errorf("Editor %v failed; prompt discarded: %w", editor,
+			err)
+	}
+
+	prompt, err := os.ReadFile(tmpFile.Name())
+	if err != nil {
+		return "", fmt.Errorf("Failed to read prompt file %v: %w", tmpFile.Name(),
+			err)
+	}
+
+	return string(prompt), nil
+}
+
+func editMain(ctx context.Context, gptCliCtx *GptCliContext,
+	args []string) error {
+
+	if len(args) != 1 {
+		return fmt.Errorf("Syntax is 'edit'\n")
+	}
+	thrGrp := gptCliCtx.curThreadGroup
+	if thrGrp.curThreadNum == 0 {
+		return fmt.Errorf("No thread is currently selected. Select one with 'thread <thread#>'.")
+	}
+
+	prompt, err := editPrompt(gptCliCtx, "")
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "gptcli: %v\n", err)
+		return nil
+	}
+	prompt = trimPrompt(prompt, gptCliCtx.prefs.TrimMode)
+	if strings.TrimSpace(prompt) == "" {
+		fmt.Printf("gptcli: Empty prompt; nothing sent.\n")
+		return nil
+	}
+
+	return interactiveThreadWork(ctx, gptCliCtx, prompt)
+}
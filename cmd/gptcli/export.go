@@ -0,0 +1,94 @@
+/* Copyright © 2024 Mike Brown. All Rights Reserved.
+ *
+ * See LICENSE file at the root of this package for license terms
+ */
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/sashabaranov/go-openai"
+)
+
+// Markdown returns thread formatted as a Markdown document with a YAML front
+// matter header. System messages are omitted.
+func (thread *GptCliThread) Markdown() string {
+	var sb strings.Builder
+
+	sb.WriteString("---\n")
+	sb.WriteString(fmt.Sprintf("name: %v\n", strconv.Quote(thread.Name)))
+	sb.WriteString(fmt.Sprintf("created: %v\n",
+		thread.CreateTime.Format(time.RFC3339)))
+	sb.WriteString("---\n")
+
+	for _, msg := range thread.Dialogue {
+		var content string
+		switch msg.Role {
+		case openai.ChatMessageRoleUser:
+			sb.WriteString("\n## You\n\n")
+			content = msg.Content
+		case openai.ChatMessageRoleAssistant:
+			sb.WriteString("\n## Assistant\n\n")
+			content = msg.Content
+		default:
+			continue
+		}
+		content = strings.TrimRight(content, "\n")
+		// terminate any unterminated code block so that it doesn't swallow
+		// the remainder of the document
+		if strings.Count(content, CodeBlockDelim)%2 == 1 {
+			content = content + "\n" + CodeBlockDelim
+		}
+		sb.WriteString(content)
+		sb.WriteString("\n")
+	}
+
+	return sb.String()
+}
+
+func exportThreadMain(ctx context.Context, gptCliCtx *GptCliContext,
+	args []string) error {
+
+	if len(args) != 3 {
+		return fmt.Errorf("Syntax is 'export <thread#> <file>' e.g. 'export 1 thread.md'\n")
+	}
+	thrGrp, threadNum, err := parseThreadNum(gptCliCtx, args[1])
+	if err != nil {
+		return err
+	}
+	if threadNum > thrGrp.totThreads || threadNum == 0 {
+		return fmt.Errorf(ThreadNoExistErrFmt, args[1])
+	}
+	thread := thrGrp.threads[threadNum-1]
+	fileName := args[2]
+
+	_, err = os.Stat(fileName)
+	if err == nil {
+		fmt.Printf("%v already exists. Overwrite it? (Y/N) [N]: ", fileName)
+		confirm, err := gptCliCtx.input.ReadString('\n')
+		if err != nil {
+			return err
+		}
+		confirm = strings.ToUpper(strings.TrimSpace(confirm))
+		if len(confirm) == 0 || confirm[0] != 'Y' {
+			fmt.Printf("gptcli: Thread not exported.\n")
+			return nil
+		}
+	} else if !os.IsNotExist(err) {
+		return fmt.Errorf("Could not stat %v: %w", fileName, err)
+	}
+
+	err = os.WriteFile(fileName, []byte(thread.Markdown()), 0644)
+	if err != nil {
+		return fmt.Errorf("Failed to export thread %v to %v: %w", args[1],
+			fileName, err)
+	}
+	fmt.Printf("gptcli: Exported thread %v to %v.\n", args[1], fileName)
+
+	return nil
+}
@@ -0,0 +1,71 @@
+/* Copyright © 2024 Mike Brown. All Rights Reserved.
+ *
+ * See LICENSE file at the root of this package for license terms
+ */
+package main
+
+import (
+	"errors"
+	"fmt"
+	"time"
+)
+
+// IdleWarnPeriod is how long before an idle exit the user is warned.
+const IdleWarnPeriod = time.Minute
+
+var errIdleExit = errors.New("idle timeout")
+
+// idleTimeout returns how long gptcli may wait at its prompt for input
+// before exiting, or 0 if it should wait indefinitely.
+func (prefs *Prefs) idleTimeout() time.Duration {
+	return time.Duration(prefs.IdleExitMins) * time.Minute
+}
+
+// idleSchedule returns how long to wait for input before warning the user of
+// an impending idle exit, and how long after the warning to exit. The warning
+// is given IdleWarnPeriod before exiting, or halfway through timeouts shorter
+// than twice that.
+func idleSchedule(timeout time.Duration) (time.Duration, time.Duration) {
+	warnPeriod := min(IdleWarnPeriod, timeout/2)
+
+	return timeout - warnPeriod, warnPeriod
+}
+
+type inputResult struct {
+	text string
+	err  error
+}
+
+// readInput reads a line of input from the user. If an idle timeout is
+// configured and no input arrives in time, the user is warned and then
+// errIdleExit is returned. As it only applies while waiting at the prompt, an
+// idle exit can never interrupt a request in progress.
+func (gptCliCtx *GptCliContext) readInput() (string, error) {
+	timeout := gptCliCtx.prefs.idleTimeout()
+	if timeout <= 0 {
+		return gptCliCtx.input.ReadString('\n')
+	}
+
+	inputCh := make(chan inputResult, 1)
+	go func() {
+		text, err := gptCliCtx.input.ReadString('\n')
+		inputCh <- inputResult{text: text, err: err}
+	}()
+
+	warnAfter, exitAfter := idleSchedule(timeout)
+	select {
+	case result := <-inputCh:
+		return result.text, result.err
+	case <-gptCliCtx.after(warnAfter):
+	}
+
+	fmt.Printf("\ngptcli: No input for %v; exiting in %v. Press Enter to stay.\n",
+		warnAfter, exitAfter)
+	select {
+	case result := <-inputCh:
+		return result.text, result.err
+	case <-gptCliCtx.after(exitAfter):
+	}
+
+	return "", errIdleExit
+}
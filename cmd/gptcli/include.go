@@ -0,0 +1,103 @@
+/* Copyright © 2024 Mike Brown. All Rights Reserved.
+ *
+ * See LICENSE file at the root of this package for license terms
+ */
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"github.com/sashabaranov/go-openai"
+)
+
+var includeRegex = regexp.MustCompile(`\{\{include:([^{}]*)\}\}`)
+
+// expandIncludes replaces each {{include:<name>}} in text with the contents of
+// the file <name> within promptsDir. Included partials may themselves include
+// other partials; stack holds the chain of partials currently being expanded
+// so that cycles can be reported.
+func expandIncludes(text string, promptsDir string,
+	stack []string) (string, error) {
+
+	matches := includeRegex.FindAllStringSubmatchIndex(text, -1)
+	if len(matches) == 0 {
+		return text, nil
+	}
+
+	var sb strings.Builder
+	prevEnd := 0
+	for _, m := range matches {
+		sb.WriteString(text[prevEnd:m[0]])
+		prevEnd = m[1]
+
+		name := strings.TrimSpace(text[m[2]:m[3]])
+		if name == "" || name == "." || name == ".." ||
+			strings.ContainsAny(name, `/\`) {
+			return "", fmt.Errorf("Invalid system message include %q", name)
+		}
+		for _, s := range stack {
+			if s == name {
+				return "", fmt.Errorf("System message include cycle detected: %v -> %v",
+					strings.Join(stack, " -> "), name)
+			}
+		}
+
+		includePath := filepath.Join(promptsDir, name)
+		partial, err := os.ReadFile(includePath)
+		if os.IsNotExist(err) {
+			return "", fmt.Errorf("System message include %v not found; create %v",
+				name, includePath)
+		} else if err != nil {
+			return "", fmt.Errorf("Failed to read system message include %v: %w",
+				includePath, err)
+		}
+		expanded, err := expandIncludes(string(partial), promptsDir,
+			append(stack, name))
+		if err != nil {
+			return "", err
+		}
+		sb.WriteString(expanded)
+	}
+	sb.WriteString(text[prevEnd:])
+
+	return sb.String(), nil
+}
+
+// expandSystemMsgs returns dialogue with any includes within its system
+// messages expanded. dialogue itself is not modified so that threads retain
+// the unexpanded system message.
+func expandSystemMsgs(
+	dialogue []openai.ChatCompletionMessage) ([]openai.ChatCompletionMessage,
+	error) {
+
+	var expandedDialogue []openai.ChatCompletionMessage
+	for idx, msg := range dialogue {
+		if msg.Role != openai.ChatMessageRoleSystem ||
+			!includeRegex.MatchString(msg.Content) {
+			continue
+		}
+		promptsDir, err := getPromptsDir()
+		if err != nil {
+			return nil, err
+		}
+		content, err := expandIncludes(msg.Content, promptsDir, nil)
+		if err != nil {
+			return nil, err
+		}
+		if expandedDialogue == nil {
+			expandedDialogue = append([]openai.ChatCompletionMessage{},
+				dialogue...)
+		}
+		expandedDialogue[idx].Content = content
+	}
+
+	if expandedDialogue == nil {
+		return dialogue, nil
+	}
+
+	return expandedDialogue, nil
+}
@@ -0,0 +1,31 @@
+/* Copyright © 2024 Mike Brown. All Rights Reserved.
+ *
+ * See LICENSE file at the root of this package for license terms
+ */
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+)
+
+// quietMode suppresses informational messages; set via --quiet or the quiet
+// preference.
+var quietMode bool
+
+// infoOutput is where informational messages are written when not in quiet
+// mode.
+var infoOutput io.Writer = os.Stderr
+
+// logInfo writes a non-essential, informational message, such as a progress
+// or notice line, to stderr unless quiet mode is enabled. Warnings and errors
+// should continue to be written to os.Stderr directly so that they are never
+// silenced.
+func logInfo(format string, args ...any) {
+	if quietMode {
+		return
+	}
+
+	fmt.Fprintf(infoOutput, format, args...)
+}
@@ -9,6 +9,7 @@ import (
 	"context"
 	_ "embed"
 	"errors"
+	"flag"
 	"fmt"
 	"hash/crc32"
 	"io"
@@ -17,6 +18,7 @@ import (
 	"strconv"
 	"strings"
 	"time"
+	"unicode"
 
 	"golang.org/x/term"
 
@@ -29,13 +31,22 @@ const (
 	KeyFile               = ".openai.key"
 	PrefsFile             = "prefs.json"
 	ThreadsDir            = "threads"
+	PromptsDir            = "prompts"
 	ArchiveDir            = "archive_threads"
+	ProfilesDir           = "profiles"
+	DefaultProfileName    = "default"
 	CodeBlockDelim        = "```"
 	CodeBlockDelimNewline = "```\n"
 	ThreadParseErrFmt     = "Could not parse %v. Please enter a valid thread number.\n"
 	ThreadNoExistErrFmt   = "Thread %v does not exist. To list threads try 'ls'.\n"
-	RowFmt                = "| %8v | %18v | %18v | %18v | %-18v\n"
-	RowSpacer             = "----------------------------------------------------------------------------------------------\n"
+	HeaderTimeFmt         = "01/02/2006 03:04pm"
+	HeaderTimeOfDayFmt    = "03:04pm"
+	HeaderTime24Fmt       = "01/02/2006 15:04"
+	HeaderTimeOfDay24Fmt  = "15:04"
+	MaxStreamFlushMs      = 5000
+	TrimModeFull          = "full"
+	TrimModeEdges         = "edges"
+	TrimModeNone          = "none"
 )
 
 const SystemMsg = `You are gptcli, a CLI based utility that otherwise acts
@@ -68,10 +79,89 @@ var subCommandTab = map[string]func(ctx context.Context,
 	"quit":      exitMain,
 	"search":    searchMain,
 	"cat":       catMain,
+	"edit":      editMain,
+	"profile":   profileMain,
+	"doctor":    doctorMain,
+	"model":     modelMain,
+	"pin":       pinThreadMain,
+	"unpin":     unpinThreadMain,
+	"savecode":  saveCodeMain,
+	"move":      moveThreadMain,
+	"export":    exportThreadMain,
+	"compare":   compareMain,
+	"rename":    renameThreadMain,
+	"delete":    deleteThreadMain,
+	"merge":     mergeThreadMain,
+	"clone":     cloneThreadMain,
+	"sort":      sortMain,
+}
+
+// CmdPrefix marks input typed within a thread as a subcommand rather than a
+// prompt.
+const CmdPrefix = "/"
+
+// threadSubCommands operate on the current thread and are recognized within
+// a thread without CmdPrefix when typed without arguments.
+var threadSubCommands = map[string]bool{
+	"edit":     true,
+	"savecode": true,
+}
+
+// coreSubCommands are gptcli's original subcommands. They are recognized
+// within a thread without CmdPrefix and win ties when an abbreviation
+// matches more than one subcommand.
+var coreSubCommands = map[string]bool{
+	"help":      true,
+	"version":   true,
+	"upgrade":   true,
+	"config":    true,
+	"ls":        true,
+	"thread":    true,
+	"new":       true,
+	"summary":   true,
+	"archive":   true,
+	"unarchive": true,
+	"exit":      true,
+	"quit":      true,
+	"search":    true,
+	"cat":       true,
 }
 
 type Prefs struct {
-	SummarizePrior bool `json:"summarize_prior"`
+	SummarizePrior  bool   `json:"summarize_prior"`
+	DateFormat      string `json:"date_format,omitempty"`
+	NoRelativeDates bool   `json:"no_relative_dates,omitempty"`
+	Use24HourClock  bool   `json:"use_24_hour_clock,omitempty"`
+	TimeZone        string `json:"time_zone,omitempty"`
+	TimeAgo         bool   `json:"time_ago,omitempty"`
+	StreamFlushMs   int    `json:"stream_flush_ms,omitempty"`
+	TrimMode        string `json:"trim_mode,omitempty"`
+	Model           string `json:"model,omitempty"`
+	SystemMsg       string `json:"system_msg,omitempty"`
+	ASCIIMode       bool   `json:"ascii_mode,omitempty"`
+	MaxPromptBytes  int    `json:"max_prompt_bytes,omitempty"`
+	Quiet           bool   `json:"quiet,omitempty"`
+
+	SessionBudgetTokens int `json:"session_budget_tokens,omitempty"`
+	BudgetWarnPercent   int `json:"budget_warn_percent,omitempty"`
+
+	ModelAliases  map[string]string `json:"model_aliases,omitempty"`
+	CompareModels []string          `json:"compare_models,omitempty"`
+
+	UserPreamble      string `json:"user_preamble,omitempty"`
+	PreamblePlacement string `json:"preamble_placement,omitempty"`
+
+	SummaryStyle    string `json:"summary_style,omitempty"`
+	SummaryMaxWords int    `json:"summary_max_words,omitempty"`
+
+	BackupOnOverwrite bool `json:"backup_on_overwrite,omitempty"`
+	ThreadTree        bool `json:"thread_tree,omitempty"`
+	AutoName          bool `json:"auto_name,omitempty"`
+
+	ThreadSort   string `json:"thread_sort,omitempty"`
+	IdleExitMins int    `json:"idle_exit_mins,omitempty"`
+
+	timeLoc *time.Location
 }
 
 type GptCliContext struct {
@@ -84,22 +174,64 @@ type GptCliContext struct {
 	archiveThreadGroup *GptCliThreadGroup
 	mainThreadGroup    *GptCliThreadGroup
 	curThreadGroup     *GptCliThreadGroup
+	git                gitRunner
+	streamOutput       bool
+	editor             editorRunner
+	newClient          func(key string) internal.OpenAIClient
+	after              func(d time.Duration) <-chan time.Time
+	utf8Locale         bool
+	sessionTokens      int
+	budgetWarned       bool
 }
 
-func NewGptCliContext() *GptCliContext {
-	var clientLocal *openai.Client
-	needConfigLocal := false
-	keyText, err := loadKey()
-	if err != nil {
-		needConfigLocal = true
-	} else {
-		clientLocal = openai.NewClient(keyText)
+// SupportedModels lists the models offered when running 'config'.
+var SupportedModels = []string{
+	openai.GPT4o,
+	openai.GPT4oMini,
+	openai.GPT4Turbo,
+}
+
+const DefaultModel = openai.GPT4o
+
+func newOpenAIClient(key string) internal.OpenAIClient {
+	return openai.NewClient(key)
+}
+
+// GptCliContextOption customizes a GptCliContext as it is constructed; e.g.
+// tests use these to substitute fakes for the OpenAI client or stdin.
+type GptCliContextOption func(gptCliCtx *GptCliContext)
+
+// WithClientFactory overrides how the OpenAI client is created from the
+// user's API key.
+func WithClientFactory(
+	newClient func(key string) internal.OpenAIClient) GptCliContextOption {
+
+	return func(gptCliCtx *GptCliContext) {
+		gptCliCtx.newClient = newClient
+	}
+}
+
+// WithInput overrides where user input is read from.
+func WithInput(input io.Reader) GptCliContextOption {
+	return func(gptCliCtx *GptCliContext) {
+		gptCliCtx.input = bufio.NewReader(input)
 	}
+}
+
+// WithStreamOutput controls whether replies are printed incrementally as they
+// arrive rather than all at once; main() enables it when stdout is a
+// terminal.
+func WithStreamOutput(streamOutput bool) GptCliContextOption {
+	return func(gptCliCtx *GptCliContext) {
+		gptCliCtx.streamOutput = streamOutput
+	}
+}
 
+func NewGptCliContext(opts ...GptCliContextOption) *GptCliContext {
 	gptCliCtx := &GptCliContext{
-		client:           clientLocal,
+		client:           nil,
 		input:            bufio.NewReader(os.Stdin),
-		needConfig:       needConfigLocal,
+		needConfig:       false,
 		curSummaryToggle: false,
 		prefs: Prefs{
 			SummarizePrior: false,
@@ -108,6 +240,22 @@ func NewGptCliContext() *GptCliContext {
 		mainThreadGroup:    nil,
 		curThreadGroup:     nil,
 		threadGroups:       make([]*GptCliThreadGroup, 0),
+		git:                runGit,
+		streamOutput:       false,
+		editor:             runEditor,
+		newClient:          newOpenAIClient,
+		after:              time.After,
+		utf8Locale:         checkUTF8Locale(os.Getenv) == nil,
+	}
+	for _, opt := range opts {
+		opt(gptCliCtx)
+	}
+
+	keyText, err := loadKey()
+	if err != nil {
+		gptCliCtx.needConfig = true
+	} else {
+		gptCliCtx.client = gptCliCtx.newClient(keyText)
 	}
 
 	threadsDirLocal, err := getThreadsDir()
@@ -131,6 +279,12 @@ func NewGptCliContext() *GptCliContext {
 	return gptCliCtx
 }
 
+// asciiGlyphs returns true if output should be restricted to ASCII, either
+// because the user asked for it or because the locale doesn't support UTF-8.
+func (gptCliCtx *GptCliContext) asciiGlyphs() bool {
+	return gptCliCtx.prefs.ASCIIMode || !gptCliCtx.utf8Locale
+}
+
 func (gptCliCtx *GptCliContext) load() error {
 	err := gptCliCtx.loadPrefs()
 	if err != nil {
@@ -139,7 +293,11 @@ func (gptCliCtx *GptCliContext) load() error {
 	if gptCliCtx.needConfig {
 		return nil
 	}
+	if gptCliCtx.prefs.Quiet {
+		quietMode = true
+	}
 	for _, thrGrp := range gptCliCtx.threadGroups {
+		thrGrp.sortMode = gptCliCtx.prefs.threadSort()
 		err := thrGrp.loadThreads()
 		if err != nil {
 			return err
@@ -214,6 +372,17 @@ func printToScreen(str2print string) {
 	fmt.Printf("%v", str2print)
 }
 
+// getTermWidth returns the width of the terminal attached to stdout, or 0 if
+// stdout is not a terminal.
+func getTermWidth() int {
+	width, _, err := term.GetSize(int(os.Stdout.Fd()))
+	if err != nil {
+		return 0
+	}
+
+	return width
+}
+
 func genUniqFileName(name string, cTime time.Time) string {
 	return fmt.Sprintf("%v_%v.json",
 		strconv.FormatUint(uint64(crc32.ChecksumIEEE([]byte(name))), 16),
@@ -272,9 +441,7 @@ func searchMain(ctx context.Context, gptCliCtx *GptCliContext,
 	}
 	searchStrs := args[1:]
 
-	var sb strings.Builder
-
-	sb.WriteString(threadGroupHeaderString())
+	tbl := newThreadTable(gptCliCtx)
 
 	for _, thrGrp := range gptCliCtx.threadGroups {
 		for tidx, t := range thrGrp.threads {
@@ -286,14 +453,12 @@ func searchMain(ctx context.Context, gptCliCtx *GptCliContext,
 			}
 			if count == len(searchStrs) {
 				threadNum := fmt.Sprintf("%v%v", thrGrp.prefix, tidx+1)
-				sb.WriteString(t.HeaderString(threadNum))
+				tbl.addRow(t.HeaderCells(threadNum, &gptCliCtx.prefs))
 			}
 		}
 	}
 
-	sb.WriteString(threadGroupFooterString())
-
-	fmt.Printf("%v", sb.String())
+	fmt.Printf("%v", tbl.String(getTermWidth()))
 
 	return nil
 }
@@ -317,28 +482,60 @@ func getMultiLineInputRemainder(gptCliCtx *GptCliContext) (string, error) {
 	return ret, nil
 }
 
+// trimPrompt removes surrounding whitespace from a prompt according to mode:
+// TrimModeFull removes all leading and trailing whitespace, TrimModeEdges
+// (the default) only removes surrounding blank lines and trailing whitespace
+// so that the indentation of the first line is preserved, and TrimModeNone
+// leaves the prompt untouched.
+func trimPrompt(prompt string, mode string) string {
+	switch mode {
+	case TrimModeFull:
+		return strings.TrimSpace(prompt)
+	case TrimModeNone:
+		return prompt
+	}
+
+	lines := strings.Split(prompt, "\n")
+	start := 0
+	for start < len(lines) && strings.TrimSpace(lines[start]) == "" {
+		start++
+	}
+	end := len(lines)
+	for end > start && strings.TrimSpace(lines[end-1]) == "" {
+		end--
+	}
+
+	return strings.TrimRightFunc(strings.Join(lines[start:end], "\n"),
+		unicode.IsSpace)
+}
+
+// getCmdOrPrompt reads the next non-blank command or prompt, including any
+// multi-line code block, as entered; only a single line's terminating newline
+// is removed. Other whitespace is left for the caller to trim so that prompts
+// can honor Prefs.TrimMode.
 func getCmdOrPrompt(gptCliCtx *GptCliContext) (string, error) {
 	var cmdOrPrompt string
 	var err error
 	thrGrp := gptCliCtx.curThreadGroup
-	for len(cmdOrPrompt) == 0 {
+	for strings.TrimSpace(cmdOrPrompt) == "" {
 		if thrGrp.curThreadNum == 0 {
 			fmt.Printf("gptcli> ")
 		} else {
 			fmt.Printf("gptcli/%v> ",
 				thrGrp.threads[thrGrp.curThreadNum-1].Name)
 		}
-		cmdOrPrompt, err = gptCliCtx.input.ReadString('\n')
+		cmdOrPrompt, err = gptCliCtx.readInput()
 		if err != nil {
 			return "", err
 		}
-		cmdOrPrompt = strings.TrimSpace(cmdOrPrompt)
-		if strings.HasSuffix(cmdOrPrompt, CodeBlockDelim) {
+		if strings.HasSuffix(strings.TrimSpace(cmdOrPrompt), CodeBlockDelim) {
 			text2append, err := getMultiLineInputRemainder(gptCliCtx)
 			if err != nil {
 				return "", err
 			}
-			cmdOrPrompt = fmt.Sprintf("%v\n%v", cmdOrPrompt, text2append)
+			cmdOrPrompt = fmt.Sprintf("%v%v", cmdOrPrompt, text2append)
+		} else {
+			cmdOrPrompt = strings.TrimRight(cmdOrPrompt, "\r\n")
 		}
 	}
 
@@ -352,16 +549,20 @@ func summarizeDialogue(ctx context.Context, gptCliCtx *GptCliContext,
 	error) {
 
 	summaryDialogue := []openai.ChatCompletionMessage{
-		{Role: openai.ChatMessageRoleSystem, Content: SystemMsg},
+		{Role: openai.ChatMessageRoleSystem, Content: gptCliCtx.prefs.systemMsg()},
 	}
 
 	msg := openai.ChatCompletionMessage{
 		Role:    openai.ChatMessageRoleSystem,
-		Content: SummarizeMsg,
+		Content: gptCliCtx.prefs.summarizePrompt(),
+	}
+	dialogue, err := expandSystemMsgs(dialogue)
+	if err != nil {
+		return summaryDialogue, err
 	}
 	dialogue = append(dialogue, msg)
 
-	fmt.Printf("gptcli: summarizing...\n")
+	logInfo("gptcli: summarizing...\n")
 	resp, err := gptCliCtx.client.CreateChatCompletion(ctx,
 		openai.ChatCompletionRequest{
 			Model:    openai.GPT4oMini,
@@ -371,6 +572,7 @@ func summarizeDialogue(ctx context.Context, gptCliCtx *GptCliContext,
 	if err != nil {
 		return summaryDialogue, err
 	}
+	gptCliCtx.accrueUsage(resp.Usage)
 	if len(resp.Choices) != 1 {
 		return summaryDialogue, fmt.Errorf("gptcli: BUG: Expected 1 response, got %v",
 			len(resp.Choices))
@@ -415,76 +617,164 @@ func splitBlocks(text string) []string {
 	return blocks
 }
 
+// getSubCmd returns the subcommand selected by cmdArgs, or nil if cmdArgs
+// does not name one. Within a thread only the original subcommands, plus
+// edit and savecode when typed alone, are recognized as is; anything else is
+// a prompt unless prefixed with CmdPrefix, e.g. '/rename 2 notes', so that a
+// prompt such as 'delete the second paragraph' is sent to the thread.
 func (gptCliCtx *GptCliContext) getSubCmd(
-	cmdOrPrompt string) func(context.Context, *GptCliContext, []string) error {
+	cmdArgs []string) func(context.Context, *GptCliContext, []string) error {
 
+	cmdOrPrompt, hasPrefix := strings.CutPrefix(cmdArgs[0], CmdPrefix)
+	if cmdOrPrompt == "" {
+		return nil
+	}
+	if gptCliCtx.curThreadGroup.curThreadNum != 0 && !hasPrefix {
+		if coreSubCommands[cmdOrPrompt] ||
+			(threadSubCommands[cmdOrPrompt] && len(cmdArgs) == 1) {
+			return subCommandTab[cmdOrPrompt]
+		}
+		return nil
+	}
 	subCmdFunc, ok := subCommandTab[cmdOrPrompt]
 	if ok {
 		return subCmdFunc
 	}
-	if gptCliCtx.curThreadGroup.curThreadNum != 0 {
-		return nil
-	} // else we're not in a current thread; find closest match to allow
-	// aliasing. e.g. allow user to type 'a' instead of 'archive' if there's
-	// no other subcommand that starts with 'a'.
+	// find closest match to allow aliasing. e.g. allow user to type 'a'
+	// instead of 'archive' if there's no other subcommand that starts with
+	// 'a'.
 
 	var subCmdFound string
+	var coreCmdFound string
+	ambiguous := false
+	ambiguousCore := false
 	for k, _ := range subCommandTab {
 		if strings.HasPrefix(k, cmdOrPrompt) {
 			if subCmdFound != "" {
-				// ambiguous
-				return nil
+				ambiguous = true
 			}
-
 			subCmdFound = k
+			if coreSubCommands[k] {
+				if coreCmdFound != "" {
+					ambiguousCore = true
+				}
+				coreCmdFound = k
+			}
+		}
+	}
+	if !ambiguous {
+		return subCommandTab[subCmdFound]
+	}
+	// prefer the original commands so that their existing aliases continue
+	// to work as new commands are added; e.g. 'e' still means 'exit' even
+	// though 'edit' and 'export' also start with 'e'.
+	if coreCmdFound == "" || ambiguousCore {
+		return nil
+	}
+
+	return subCommandTab[coreCmdFound]
+}
+
+// run reads and executes commands and prompts until the user exits or an
+// error occurs.
+func (gptCliCtx *GptCliContext) run(ctx context.Context) error {
+	for {
+		fullCmdOrPrompt, err := getCmdOrPrompt(gptCliCtx)
+		if err != nil {
+			return err
+		}
+		err = gptCliCtx.runCommand(ctx, fullCmdOrPrompt)
+		if err != nil {
+			return err
 		}
 	}
+}
+
+// runCommand executes a single menu command or, when a thread is selected,
+// sends fullCmdOrPrompt to OpenAI as a prompt within that thread.
+func (gptCliCtx *GptCliContext) runCommand(ctx context.Context,
+	fullCmdOrPrompt string) error {
+
+	cmdArgs := strings.Split(strings.TrimSpace(fullCmdOrPrompt), " ")
+	cmdOrPrompt := cmdArgs[0]
+	subCmdFunc := gptCliCtx.getSubCmd(cmdArgs)
+	if subCmdFunc == nil {
+		if gptCliCtx.curThreadGroup.curThreadNum == 0 {
+			fmt.Fprintf(os.Stderr, "gptcli: Unknown command %v. Try	'help'.\n",
+				cmdOrPrompt)
+			return nil
+		} // else we're already in a thread
+		return interactiveThreadWork(ctx, gptCliCtx,
+			trimPrompt(fullCmdOrPrompt, gptCliCtx.prefs.TrimMode))
+	}
 
-	return subCommandTab[subCmdFound]
+	cmdArgs[0] = strings.TrimPrefix(cmdOrPrompt, CmdPrefix)
+
+	return subCmdFunc(ctx, gptCliCtx, cmdArgs)
 }
 
 func main() {
-	checkAndPrintUpgradeWarning()
+	profile := flag.String("profile", "",
+		"Use the named configuration profile")
+	flag.BoolVar(&quietMode, "quiet", false,
+		"Suppress non-essential informational messages")
+	printConfigPath := flag.Bool("print-config-path", false,
+		"Print the locations of gptcli's configuration and data, then exit")
+	batchPath := flag.String("batch", "",
+		"Send each line of the given file as a prompt, then exit")
+	batchOut := flag.String("batch-out", "",
+		"Write batch replies to the given file instead of stdout")
+	var batchOptions batchOpts
+	flag.BoolVar(&batchOptions.continueOnError, "continue-on-error", false,
+		"Continue a batch after a prompt fails")
+	flag.StringVar(&batchOptions.checkpointPath, "checkpoint", "",
+		"Record batch progress in the given file and resume from it")
+	flag.Parse()
+	err := setProfile(*profile)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "gptcli: %v\n", err)
+		os.Exit(1)
+	}
+	if *printConfigPath {
+		err = printConfigPaths(os.Stdout)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "gptcli: %v\n", err)
+			os.Exit(1)
+		}
+		os.Exit(0)
+	}
 
 	ctx := context.Background()
-	gptCliCtx := NewGptCliContext()
+	gptCliCtx := NewGptCliContext(
+		WithStreamOutput(term.IsTerminal(int(os.Stdout.Fd()))))
 
 	if !gptCliCtx.needConfig {
 		checkAndUpgradeConfig()
 	}
 
-	err := gptCliCtx.load()
+	err = gptCliCtx.load()
 	if err != nil {
 		fmt.Fprintf(os.Stderr, "gptcli: Failed to load: %v\n", err)
 		os.Exit(1)
 	}
+	// after load() so that Prefs.Quiet can silence the notice
+	checkAndPrintUpgradeWarning()
 
-	var fullCmdOrPrompt string
-	var cmdOrPrompt string
-	for {
-		fullCmdOrPrompt, err = getCmdOrPrompt(gptCliCtx)
-		if err != nil {
-			break
-		}
-		cmdArgs := strings.Split(fullCmdOrPrompt, " ")
-		cmdOrPrompt = cmdArgs[0]
-		subCmdFunc := gptCliCtx.getSubCmd(cmdOrPrompt)
-		if subCmdFunc == nil {
-			if gptCliCtx.curThreadGroup.curThreadNum == 0 {
-				fmt.Fprintf(os.Stderr, "gptcli: Unknown command %v. Try	'help'.\n",
-					cmdOrPrompt)
-				continue
-			} // else we're already in a thread
-			err = interactiveThreadWork(ctx, gptCliCtx, fullCmdOrPrompt)
-		} else {
-			err = subCmdFunc(ctx, gptCliCtx, cmdArgs)
-		}
-
+	if *batchPath != "" {
+		err = batchMain(ctx, gptCliCtx, *batchPath, *batchOut, batchOptions)
 		if err != nil {
-			break
+			fmt.Fprintf(os.Stderr, "gptcli: %v\n", strings.TrimSpace(err.Error()))
+			os.Exit(1)
 		}
+		os.Exit(0)
 	}
 
+	err = gptCliCtx.run(ctx)
+	if errors.Is(err, errIdleExit) {
+		fmt.Printf("gptcli: No input for %v minutes. quitting.\n",
+			gptCliCtx.prefs.IdleExitMins)
+		return
+	}
 	if err != nil && !errors.Is(err, io.EOF) {
 		fmt.Fprintf(os.Stderr, "gptcli: %v. quitting.\n", err)
 		os.Exit(1)
@@ -4,12 +4,18 @@ import (
 	"bufio"
 	"context"
 	"encoding/json"
+	"fmt"
 	"io"
 	"os"
+	"os/exec"
 	"path/filepath"
+	"reflect"
+	"slices"
 	"strconv"
+	"strings"
 	"testing"
 	"time"
+	"unicode/utf8"
 
 	//"github.com/mikeb26/gptcli/internal"
 
@@ -362,3 +368,2836 @@ func TestArchiveThreadMain(t *testing.T) {
 	_, err = os.Stat(archiveFilePath)
 	assert.Nil(t, err)
 }
+
+func TestFormatHeaderTime(t *testing.T) {
+	loc := time.FixedZone("test", -5*60*60)
+	now := time.Date(2024, time.March, 10, 18, 30, 0, 0, loc)
+	today := time.Date(2024, time.March, 10, 9, 15, 0, 0, loc)
+	yesterday := time.Date(2024, time.March, 9, 21, 5, 0, 0, loc)
+	older := time.Date(2024, time.February, 1, 14, 45, 0, 0, loc)
+
+	tests := []struct {
+		name  string
+		t     time.Time
+		prefs Prefs
+		want  string
+	}{
+		{
+			name:  "default layout",
+			t:     older,
+			prefs: Prefs{},
+			want:  "02/01/2024 02:45pm",
+		},
+		{
+			name:  "default layout today",
+			t:     today,
+			prefs: Prefs{},
+			want:  "Today 09:15am",
+		},
+		{
+			name:  "default layout yesterday",
+			t:     yesterday,
+			prefs: Prefs{},
+			want:  "Yesterday 09:05pm",
+		},
+		{
+			name:  "custom layout",
+			t:     older,
+			prefs: Prefs{DateFormat: "2006-01-02 15:04"},
+			want:  "2024-02-01 14:45",
+		},
+		{
+			name:  "custom layout today",
+			t:     today,
+			prefs: Prefs{DateFormat: "2006-01-02 15:04"},
+			want:  "Today 09:15",
+		},
+		{
+			name:  "custom time first layout yesterday",
+			t:     yesterday,
+			prefs: Prefs{DateFormat: "3:04:05 PM Jan 2"},
+			want:  "Yesterday 9:05:00 PM",
+		},
+		{
+			name:  "custom date only layout today",
+			t:     today,
+			prefs: Prefs{DateFormat: "Jan 2 2006"},
+			want:  "Today",
+		},
+		{
+			name: "relative labels off",
+			t:    today,
+			prefs: Prefs{DateFormat: "Jan 2 2006 15:04",
+				NoRelativeDates: true},
+			want: "Mar 10 2024 09:15",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, formatHeaderTime(tt.t, now, &tt.prefs))
+		})
+	}
+}
+
+func TestValidateDateFormat(t *testing.T) {
+	assert.NoError(t, validateDateFormat(""))
+	assert.NoError(t, validateDateFormat(HeaderTimeFmt))
+	assert.NoError(t, validateDateFormat("2006-01-02 15:04"))
+	assert.Error(t, validateDateFormat("not a layout"))
+}
+
+func TestFormatHeaderTime24HourClock(t *testing.T) {
+	loc := time.FixedZone("test", 2*60*60)
+	now := time.Date(2024, time.March, 10, 0, 1, 0, 0, loc)
+	justAfterMidnight := time.Date(2024, time.March, 10, 0, 0, 30, 0, loc)
+	justBeforeMidnight := time.Date(2024, time.March, 9, 23, 59, 0, 0, loc)
+	twoDaysAgo := time.Date(2024, time.March, 8, 23, 59, 0, 0, loc)
+
+	tests := []struct {
+		name   string
+		t      time.Time
+		want12 string
+		want24 string
+	}{
+		{
+			name:   "just after midnight",
+			t:      justAfterMidnight,
+			want12: "Today 12:00am",
+			want24: "Today 00:00",
+		},
+		{
+			name:   "just before midnight",
+			t:      justBeforeMidnight,
+			want12: "Yesterday 11:59pm",
+			want24: "Yesterday 23:59",
+		},
+		{
+			name:   "two days ago",
+			t:      twoDaysAgo,
+			want12: "03/08/2024 11:59pm",
+			want24: "03/08/2024 23:59",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			prefs := Prefs{}
+			assert.Equal(t, tt.want12, formatHeaderTime(tt.t, now, &prefs))
+			prefs.Use24HourClock = true
+			assert.Equal(t, tt.want24, formatHeaderTime(tt.t, now, &prefs))
+		})
+	}
+}
+
+func TestPrefsTimeZone(t *testing.T) {
+	prefs := Prefs{TimeZone: "Asia/Tokyo"}
+	prefs.sanitize()
+	assert.Equal(t, "Asia/Tokyo", prefs.location().String())
+
+	// 2024-03-10 16:30 UTC is already the 11th in Tokyo
+	now := time.Date(2024, time.March, 10, 16, 30, 0, 0, time.UTC).
+		In(prefs.location())
+	ts := time.Date(2024, time.March, 10, 15, 0, 0, 0, time.UTC)
+	assert.Equal(t, "Today 12:00am", formatHeaderTime(ts, now, &prefs))
+	ts = time.Date(2024, time.March, 10, 14, 0, 0, 0, time.UTC)
+	assert.Equal(t, "Yesterday 11:00pm", formatHeaderTime(ts, now, &prefs))
+	prefs.NoRelativeDates = true
+	assert.Equal(t, "03/10/2024 11:00pm", formatHeaderTime(ts, now, &prefs))
+
+	prefs = Prefs{TimeZone: "Not/A_Zone"}
+	prefs.sanitize()
+	assert.Equal(t, time.Local, prefs.location())
+}
+
+func TestHumanizeDuration(t *testing.T) {
+	tests := []struct {
+		d    time.Duration
+		want string
+	}{
+		{-5 * time.Second, "0s ago"},
+		{0, "0s ago"},
+		{42 * time.Second, "42s ago"},
+		{59*time.Second + 999*time.Millisecond, "59s ago"},
+		{time.Minute, "1m ago"},
+		{59 * time.Minute, "59m ago"},
+		{time.Hour, "1h ago"},
+		{3*time.Hour + 20*time.Minute, "3h ago"},
+		{23*time.Hour + 59*time.Minute, "23h ago"},
+		{24 * time.Hour, "1d ago"},
+		{10*24*time.Hour + 5*time.Hour, "10d ago"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.want, func(t *testing.T) {
+			assert.Equal(t, tt.want, humanizeDuration(tt.d))
+		})
+	}
+}
+
+func TestComputeColumnWidths(t *testing.T) {
+	rows := [][]string{
+		{"1", "Today 09:15am", "Today 09:15am", "02/01/2024 02:45pm", "short"},
+		{"12", "3h ago", "2d ago", "02/01/2024 02:45pm",
+			"a considerably longer thread name"},
+	}
+
+	widths := computeColumnWidths(rows, 0)
+	assert.Equal(t, []int{7, 13, 13, 18, 33}, widths)
+	assert.Equal(t, 2+7+3+13+3+13+3+18+3+33, tableWidth(widths))
+
+	// wide terminal; nothing changes
+	assert.Equal(t, widths, computeColumnWidths(rows, 200))
+
+	// narrow terminal; only the name column shrinks
+	widths = computeColumnWidths(rows, 80)
+	assert.Equal(t, []int{7, 13, 13, 18, 15}, widths)
+	assert.Equal(t, 80, tableWidth(widths))
+
+	// very narrow terminal; the name column keeps a minimum width
+	widths = computeColumnWidths(rows, 40)
+	assert.Equal(t, threadTableMinNameWidth, widths[threadTableNameCol])
+}
+
+func TestThreadTableString(t *testing.T) {
+	tbl := &threadTable{}
+	tbl.addRow([]string{"1", "a", "b", "c", "short"})
+	tbl.addRow([]string{"a2", "a", "b", "c", "a name that will not fit"})
+
+	spacer := strings.Repeat("-", 71) + "\n"
+	expected := spacer +
+		"| Thread# | Last Accessed | Last Modified | Created | Name             \n" +
+		spacer +
+		"|       1 |             a |             b |       c | short            \n" +
+		"|      a2 |             a |             b |       c | a name that will…\n" +
+		spacer
+	assert.Equal(t, expected, tbl.String(71))
+
+	assert.Equal(t, "abc", elide("abc", 3, threadTableElision))
+	assert.Equal(t, "ab…", elide("abcd", 3, threadTableElision))
+	assert.Equal(t, "", elide("abcd", 0, threadTableElision))
+	assert.Equal(t, "a...", elide("abcdef", 4, threadTableASCIIElision))
+	assert.Equal(t, "ab", elide("abcdef", 2, threadTableASCIIElision))
+}
+
+func TestSelectElision(t *testing.T) {
+	assert.Equal(t, "…", selectElision(true))
+	assert.Equal(t, "...", selectElision(false))
+
+	gptCliCtx := NewGptCliContext()
+	gptCliCtx.utf8Locale = false
+	tbl := newThreadTable(gptCliCtx)
+	tbl.addRow([]string{"1", "a", "b", "c", "a name that will not fit"})
+	assert.Contains(t, tbl.String(71), "| a name that wi...\n")
+
+	gptCliCtx.utf8Locale = true
+	assert.Equal(t, threadTableElision, newThreadTable(gptCliCtx).elision)
+	gptCliCtx.prefs.ASCIIMode = true
+	assert.Equal(t, threadTableASCIIElision, newThreadTable(gptCliCtx).elision)
+}
+
+func fakeGit(responses map[string]string) gitRunner {
+	return func(args ...string) (string, error) {
+		out, ok := responses[strings.Join(args, " ")]
+		if !ok {
+			return "", fmt.Errorf("git %v failed", strings.Join(args, " "))
+		}
+		return out, nil
+	}
+}
+
+func TestGetRepoContext(t *testing.T) {
+	tests := []struct {
+		name      string
+		responses map[string]string
+		want      string
+		wantPath  string
+	}{
+		{
+			name:      "not a repo",
+			responses: map[string]string{},
+			want:      "",
+		},
+		{
+			name: "branch",
+			responses: map[string]string{
+				"rev-parse --show-toplevel": "/home/user/src/gptcli",
+				"symbolic-ref --short HEAD": "feature/foo",
+			},
+			want:     "gptcli@feature/foo",
+			wantPath: "/home/user/src/gptcli",
+		},
+		{
+			name: "detached head",
+			responses: map[string]string{
+				"rev-parse --show-toplevel": "/home/user/src/gptcli",
+				"rev-parse --short HEAD":    "5dd47ff",
+			},
+			want:     "gptcli@5dd47ff",
+			wantPath: "/home/user/src/gptcli",
+		},
+		{
+			name: "no commits or branch",
+			responses: map[string]string{
+				"rev-parse --show-toplevel": "/home/user/src/gptcli",
+			},
+			want:     "gptcli",
+			wantPath: "/home/user/src/gptcli",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			repo, repoPath := getRepoContext(fakeGit(tt.responses))
+			assert.Equal(t, tt.want, repo)
+			assert.Equal(t, tt.wantPath, repoPath)
+		})
+	}
+}
+
+func TestNewThreadMainRepoDefault(t *testing.T) {
+	gptCliCtx := NewGptCliContext()
+	gptCliCtx.needConfig = false
+	gptCliCtx.git = fakeGit(map[string]string{
+		"rev-parse --show-toplevel": "/home/user/src/gptcli",
+		"symbolic-ref --short HEAD": "main",
+	})
+
+	gptCliCtx.input = bufio.NewReader(strings.NewReader("\nexplicit\n"))
+	err := newThreadMain(context.Background(), gptCliCtx, []string{"new"})
+	assert.NoError(t, err)
+	thread := gptCliCtx.mainThreadGroup.threads[0]
+	assert.Equal(t, "gptcli@main", thread.Name)
+	assert.Equal(t, "gptcli@main", thread.Repo)
+	assert.Equal(t, "/home/user/src/gptcli", thread.RepoPath)
+
+	err = newThreadMain(context.Background(), gptCliCtx, []string{"new"})
+	assert.NoError(t, err)
+	thread = gptCliCtx.mainThreadGroup.threads[1]
+	assert.Equal(t, "explicit", thread.Name)
+	assert.Equal(t, "gptcli@main", thread.Repo)
+}
+
+func TestThreadInRepo(t *testing.T) {
+	threads := []*GptCliThread{
+		{Name: "one", Repo: "gptcli@main", RepoPath: "/src/gptcli"},
+		{Name: "two", Repo: "gptcli@feature", RepoPath: "/src/gptcli/"},
+		{Name: "three", Repo: "other@main", RepoPath: "/src/other"},
+		{Name: "four"},
+		{Name: "five", Repo: "gptcli@main", RepoPath: "/tmp/gptcli"},
+	}
+
+	var matched []string
+	for _, thread := range threads {
+		if threadInRepo(thread, "/src/gptcli") {
+			matched = append(matched, thread.Name)
+		}
+	}
+	assert.Equal(t, []string{"one", "two"}, matched)
+	assert.False(t, threadInRepo(threads[3], ""))
+
+	thrGrp := NewGptCliThreadGroup("", "")
+	for idx, thread := range threads {
+		thread.CreateTime = time.Unix(int64(idx), 0)
+		thrGrp.addThread(thread)
+	}
+	tbl := &threadTable{}
+	thrGrp.addToTable(tbl, &Prefs{}, func(t *GptCliThread) bool {
+		return threadInRepo(t, "/src/other")
+	})
+	assert.Len(t, tbl.rows, 1)
+	assert.Equal(t, "3", tbl.rows[0][0])
+	assert.Equal(t, "three", tbl.rows[0][threadTableNameCol])
+}
+
+type fakeChatStream struct {
+	chunks []string
+	idx    int
+	onRecv func(idx int)
+	err    error
+}
+
+func (fs *fakeChatStream) Recv() (openai.ChatCompletionStreamResponse, error) {
+	if fs.onRecv != nil {
+		fs.onRecv(fs.idx)
+	}
+	if fs.idx >= len(fs.chunks) {
+		if fs.err != nil {
+			return openai.ChatCompletionStreamResponse{}, fs.err
+		}
+		return openai.ChatCompletionStreamResponse{}, io.EOF
+	}
+	chunk := fs.chunks[fs.idx]
+	fs.idx++
+
+	return openai.ChatCompletionStreamResponse{
+		Choices: []openai.ChatCompletionStreamChoice{
+			{Delta: openai.ChatCompletionStreamChoiceDelta{Content: chunk}},
+		},
+	}, nil
+}
+
+func (fs *fakeChatStream) Close() error {
+	return nil
+}
+
+type recordingWriter struct {
+	strings.Builder
+	flushes int
+}
+
+func (rw *recordingWriter) Flush() error {
+	rw.flushes++
+	return nil
+}
+
+func TestPrintStream(t *testing.T) {
+	chunks := []string{"Here is ", "some code:\n`", "``\nfmt.Println()\n``", "`\ndone"}
+	w := &recordingWriter{}
+	stream := &fakeChatStream{chunks: chunks}
+	var seen []string
+	stream.onRecv = func(idx int) {
+		// everything before a held back backtick should already be written
+		// by the time the next chunk is requested
+		seen = append(seen, w.String())
+	}
+
+	reply, _, err := printStream(w, stream, 0)
+
+	assert.NoError(t, err)
+	assert.Equal(t, strings.Join(chunks, ""), reply)
+	assert.Equal(t, []string{
+		"",
+		"Here is ",
+		"Here is some code:\n",
+		"Here is some code:\n```\nfmt.Println()\n",
+		"Here is some code:\n```\nfmt.Println()\n```\ndone",
+	}, seen)
+	assert.Equal(t, reply+"\n", w.String())
+	assert.Equal(t, len(chunks)+1, w.flushes)
+}
+
+func TestPrintStreamError(t *testing.T) {
+	w := &recordingWriter{}
+	stream := &fakeChatStream{chunks: []string{"partial"},
+		err: fmt.Errorf("connection reset")}
+
+	reply, _, err := printStream(w, stream, 0)
+
+	assert.Error(t, err)
+	assert.Equal(t, "partial", reply)
+	assert.Equal(t, "partial\n", w.String())
+}
+
+func TestStreamPrinterCoalescing(t *testing.T) {
+	w := &recordingWriter{}
+	sp := newStreamPrinter(w, 100*time.Millisecond)
+	now := time.Date(2024, time.March, 10, 12, 0, 0, 0, time.UTC)
+	sp.now = func() time.Time { return now }
+
+	// first chunk is always written immediately
+	sp.write("a")
+	assert.Equal(t, "a", w.String())
+
+	// chunks within the interval are coalesced
+	now = now.Add(40 * time.Millisecond)
+	sp.write("b")
+	now = now.Add(40 * time.Millisecond)
+	sp.write("c")
+	assert.Equal(t, "a", w.String())
+	assert.Equal(t, 1, w.flushes)
+
+	// once the interval elapses everything pending is written together
+	now = now.Add(20 * time.Millisecond)
+	sp.write("d")
+	assert.Equal(t, "abcd", w.String())
+	assert.Equal(t, 2, w.flushes)
+
+	// the final write is never delayed
+	now = now.Add(time.Millisecond)
+	sp.write("e")
+	assert.Equal(t, "abcd", w.String())
+	sp.finish()
+	assert.Equal(t, "abcde\n", w.String())
+	assert.Equal(t, 3, w.flushes)
+}
+
+func TestPrefsStreamFlushInterval(t *testing.T) {
+	prefs := Prefs{StreamFlushMs: 50}
+	prefs.sanitize()
+	assert.Equal(t, 50*time.Millisecond, prefs.streamFlushInterval())
+
+	prefs = Prefs{StreamFlushMs: -1}
+	prefs.sanitize()
+	assert.Equal(t, time.Duration(0), prefs.streamFlushInterval())
+}
+
+func TestEditPrompt(t *testing.T) {
+	gptCliCtx := NewGptCliContext()
+	var editedPath string
+	gptCliCtx.editor = func(editor string, path string) error {
+		assert.Equal(t, "myeditor -w", editor)
+		seed, err := os.ReadFile(path)
+		assert.NoError(t, err)
+		assert.Equal(t, "seed", string(seed))
+		editedPath = path
+		return os.WriteFile(path, []byte("edited\n  prompt\n"), 0600)
+	}
+
+	t.Setenv("EDITOR", "myeditor -w")
+	prompt, err := editPrompt(gptCliCtx, "seed")
+	assert.NoError(t, err)
+	assert.Equal(t, "edited\n  prompt\n", prompt)
+	_, err = os.Stat(editedPath)
+	assert.True(t, os.IsNotExist(err))
+
+	gptCliCtx.editor = func(editor string, path string) error {
+		return fmt.Errorf("exit status 1")
+	}
+	_, err = editPrompt(gptCliCtx, "seed")
+	assert.ErrorContains(t, err, "prompt discarded")
+
+	t.Setenv("EDITOR", "")
+	_, err = editPrompt(gptCliCtx, "seed")
+	assert.ErrorContains(t, err, "$EDITOR is not set")
+}
+
+func TestTrimPrompt(t *testing.T) {
+	prompt := "\n  \n    func foo() {\n\treturn\n    }\t\n\n  \n"
+
+	assert.Equal(t, "func foo() {\n\treturn\n    }",
+		trimPrompt(prompt, TrimModeFull))
+	assert.Equal(t, "    func foo() {\n\treturn\n    }",
+		trimPrompt(prompt, TrimModeEdges))
+	assert.Equal(t, "    func foo() {\n\treturn\n    }",
+		trimPrompt(prompt, ""))
+	assert.Equal(t, prompt, trimPrompt(prompt, TrimModeNone))
+	assert.Equal(t, "", trimPrompt(" \n\t\n", TrimModeEdges))
+
+	prefs := Prefs{TrimMode: "bogus"}
+	prefs.sanitize()
+	assert.Equal(t, "", prefs.TrimMode)
+}
+
+func TestConfigMainModelSelection(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	tests := []struct {
+		name          string
+		input         string
+		listErr       error
+		wantErr       bool
+		wantModel     string
+		wantSummarize bool
+	}{
+		{
+			name:          "default model",
+			input:         "sk-test\n\nn\n\n",
+			wantModel:     openai.GPT4o,
+			wantSummarize: false,
+		},
+		{
+			name:          "select second model",
+			input:         "sk-test\n2\ny\n\n",
+			wantModel:     openai.GPT4oMini,
+			wantSummarize: true,
+		},
+		{
+			name:          "key validation failure only warns",
+			input:         "sk-test\n3\n\n\n",
+			listErr:       fmt.Errorf("401 unauthorized"),
+			wantModel:     openai.GPT4Turbo,
+			wantSummarize: false,
+		},
+		{
+			name:    "invalid selection",
+			input:   "sk-test\n9\n",
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			homeDir := t.TempDir()
+			t.Setenv("HOME", homeDir)
+
+			mockClient := internal.NewMockOpenAIClient(ctrl)
+			mockClient.EXPECT().ListModels(gomock.Any()).
+				Return(openai.ModelsList{}, tt.listErr)
+
+			gptCliCtx := NewGptCliContext()
+			gptCliCtx.input = bufio.NewReader(strings.NewReader(tt.input))
+			var gotKey string
+			gptCliCtx.newClient = func(key string) internal.OpenAIClient {
+				gotKey = key
+				return mockClient
+			}
+
+			err := configMain(context.Background(), gptCliCtx, []string{"config"})
+			assert.Equal(t, "sk-test", gotKey)
+			if tt.wantErr {
+				assert.Error(t, err)
+				return
+			}
+			assert.NoError(t, err)
+			assert.False(t, gptCliCtx.needConfig)
+			assert.Equal(t, mockClient, gptCliCtx.client)
+
+			prefsPath, err := getPrefsPath()
+			assert.NoError(t, err)
+			prefsText, err := os.ReadFile(prefsPath)
+			assert.NoError(t, err)
+			var prefs Prefs
+			assert.NoError(t, json.Unmarshal(prefsText, &prefs))
+			assert.Equal(t, tt.wantModel, prefs.model())
+			assert.Equal(t, tt.wantSummarize, prefs.SummarizePrior)
+
+			keyText, err := loadKey()
+			assert.NoError(t, err)
+			assert.Equal(t, "sk-test", keyText)
+		})
+	}
+}
+
+func TestConfigMainReplaceKey(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	tests := []struct {
+		name    string
+		input   string
+		wantKey string
+	}{
+		{
+			name:    "keep existing key",
+			input:   "\n\n\n\n",
+			wantKey: "sk-old",
+		},
+		{
+			name:    "decline replacing key",
+			input:   "n\n\n\n\n",
+			wantKey: "sk-old",
+		},
+		{
+			name:    "replace key",
+			input:   "y\nsk-new\n\n\n\n",
+			wantKey: "sk-new",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv("HOME", t.TempDir())
+			keyPath, err := getKeyPath()
+			assert.NoError(t, err)
+			assert.NoError(t, os.MkdirAll(filepath.Dir(keyPath), 0700))
+			assert.NoError(t, os.WriteFile(keyPath, []byte("sk-old"), 0600))
+
+			oldClient := internal.NewMockOpenAIClient(ctrl)
+			newClient := internal.NewMockOpenAIClient(ctrl)
+			newClient.EXPECT().ListModels(gomock.Any()).
+				Return(openai.ModelsList{}, nil)
+
+			gptCliCtx := NewGptCliContext()
+			gptCliCtx.client = oldClient
+			gptCliCtx.input = bufio.NewReader(strings.NewReader(tt.input))
+			var gotKey string
+			gptCliCtx.newClient = func(key string) internal.OpenAIClient {
+				gotKey = key
+				return newClient
+			}
+
+			err = configMain(context.Background(), gptCliCtx, []string{"config"})
+			assert.NoError(t, err)
+			assert.Equal(t, tt.wantKey, gotKey)
+			assert.Equal(t, newClient, gptCliCtx.client)
+			keyText, err := loadKey()
+			assert.NoError(t, err)
+			assert.Equal(t, tt.wantKey, keyText)
+		})
+	}
+}
+
+func TestConfigMainKeepsDateFormat(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+	t.Setenv("HOME", t.TempDir())
+
+	tests := []struct {
+		dateFormatInput string
+		wantDateFormat  string
+	}{
+		{"2006-01-02 15:04", "2006-01-02 15:04"},
+		// re-running config keeps the custom format
+		{"", "2006-01-02 15:04"},
+		{HeaderTimeFmt, ""},
+		{"", ""},
+	}
+
+	for _, tt := range tests {
+		mockClient := internal.NewMockOpenAIClient(ctrl)
+		mockClient.EXPECT().ListModels(gomock.Any()).
+			Return(openai.ModelsList{}, nil)
+		gptCliCtx := NewGptCliContext(WithClientFactory(
+			func(key string) internal.OpenAIClient { return mockClient }))
+		assert.NoError(t, gptCliCtx.load())
+		keyInput := "sk-test\n"
+		if !gptCliCtx.needConfig {
+			keyInput = "\n" // keep the key saved by the first run
+		}
+		gptCliCtx.input = bufio.NewReader(strings.NewReader(
+			keyInput + "\n\n" + tt.dateFormatInput + "\n"))
+
+		err := configMain(context.Background(), gptCliCtx, []string{"config"})
+		assert.NoError(t, err)
+		assert.Equal(t, tt.wantDateFormat, gptCliCtx.prefs.DateFormat)
+
+		prefsPath, err := getPrefsPath()
+		assert.NoError(t, err)
+		prefsText, err := os.ReadFile(prefsPath)
+		assert.NoError(t, err)
+		var prefs Prefs
+		assert.NoError(t, json.Unmarshal(prefsText, &prefs))
+		assert.Equal(t, tt.wantDateFormat, prefs.DateFormat)
+	}
+}
+
+func writeTestProfile(t *testing.T, profile string, threadName string) {
+	assert.NoError(t, setProfile(profile))
+	configDir, err := getConfigDir()
+	assert.NoError(t, err)
+	threadsDir, err := getThreadsDir()
+	assert.NoError(t, err)
+	archiveDir, err := getArchiveDir()
+	assert.NoError(t, err)
+	assert.NoError(t, os.MkdirAll(threadsDir, 0700))
+	assert.NoError(t, os.MkdirAll(archiveDir, 0700))
+	assert.NoError(t, os.WriteFile(filepath.Join(configDir, KeyFile),
+		[]byte("sk-"+profile), 0600))
+	assert.NoError(t, os.WriteFile(filepath.Join(configDir, PrefsFile),
+		[]byte(`{"model":"`+profile+`-model"}`), 0600))
+
+	thread := &GptCliThread{
+		Name:       threadName,
+		CreateTime: time.Now(),
+	}
+	thread.fileName = genUniqFileName(thread.Name, thread.CreateTime)
+	assert.NoError(t, thread.save(threadsDir))
+}
+
+func TestProfilesIsolated(t *testing.T) {
+	homeDir := t.TempDir()
+	t.Setenv("HOME", homeDir)
+	defer setProfile("")
+
+	writeTestProfile(t, "", "personal thread")
+	writeTestProfile(t, "work", "work thread")
+
+	assert.NoError(t, setProfile(DefaultProfileName))
+	configDir, err := getConfigDir()
+	assert.NoError(t, err)
+	assert.Equal(t, filepath.Join(homeDir, ".config", CommandName), configDir)
+
+	gptCliCtx := NewGptCliContext()
+	var gotKeys []string
+	gptCliCtx.newClient = func(key string) internal.OpenAIClient {
+		gotKeys = append(gotKeys, key)
+		return nil
+	}
+	assert.NoError(t, gptCliCtx.load())
+	assert.Equal(t, 1, gptCliCtx.mainThreadGroup.totThreads)
+	assert.Equal(t, "personal thread",
+		gptCliCtx.mainThreadGroup.threads[0].Name)
+
+	err = profileMain(context.Background(), gptCliCtx, []string{"profile", "work"})
+	assert.NoError(t, err)
+	assert.Equal(t, "work", profileDisplayName())
+	assert.Equal(t, filepath.Join(configDir, ProfilesDir, "work", ThreadsDir),
+		gptCliCtx.mainThreadGroup.dir)
+	assert.Equal(t, 1, gptCliCtx.mainThreadGroup.totThreads)
+	assert.Equal(t, "work thread", gptCliCtx.mainThreadGroup.threads[0].Name)
+	assert.Equal(t, "work-model", gptCliCtx.prefs.Model)
+
+	err = profileMain(context.Background(), gptCliCtx, []string{"profile", "default"})
+	assert.NoError(t, err)
+	assert.Equal(t, 1, gptCliCtx.mainThreadGroup.totThreads)
+	assert.Equal(t, "personal thread",
+		gptCliCtx.mainThreadGroup.threads[0].Name)
+	assert.Equal(t, "-model", gptCliCtx.prefs.Model)
+	assert.Equal(t, []string{"sk-work", "sk-"}, gotKeys)
+
+	err = profileMain(context.Background(), gptCliCtx, []string{"profile", "new"})
+	assert.NoError(t, err)
+	assert.True(t, gptCliCtx.needConfig)
+	assert.Equal(t, 0, gptCliCtx.mainThreadGroup.totThreads)
+
+	assert.Error(t, setProfile("../escape"))
+}
+
+func TestLoadThreadsMissingDir(t *testing.T) {
+	threadsDir := filepath.Join(t.TempDir(), "config", ThreadsDir)
+	thrGrp := NewGptCliThreadGroup("", threadsDir)
+
+	err := thrGrp.loadThreads()
+
+	assert.NoError(t, err)
+	assert.Equal(t, 0, thrGrp.totThreads)
+	assert.Empty(t, thrGrp.threads)
+	fi, err := os.Stat(threadsDir)
+	assert.NoError(t, err)
+	assert.True(t, fi.IsDir())
+	assert.Equal(t, os.FileMode(0700), fi.Mode().Perm())
+}
+
+func fakeEnv(env map[string]string) func(key string) string {
+	return func(key string) string {
+		return env[key]
+	}
+}
+
+func TestDoctorChecks(t *testing.T) {
+	assert.NoError(t, checkTTY(func(fd int) bool { return true }))
+	assert.ErrorContains(t, checkTTY(func(fd int) bool { return false }),
+		"not a terminal")
+
+	assert.NoError(t, checkColors(fakeEnv(map[string]string{"TERM": "xterm"})))
+	assert.ErrorContains(t, checkColors(fakeEnv(map[string]string{"TERM": "dumb"})),
+		"TERM")
+	assert.ErrorContains(t, checkColors(fakeEnv(map[string]string{
+		"TERM": "xterm", "NO_COLOR": "1"})), "NO_COLOR")
+
+	assert.NoError(t, checkUTF8Locale(fakeEnv(map[string]string{
+		"LANG": "en_US.UTF-8"})))
+	assert.NoError(t, checkUTF8Locale(fakeEnv(map[string]string{
+		"LANG": "C", "LC_ALL": "de_DE.utf8"})))
+	assert.ErrorContains(t, checkUTF8Locale(fakeEnv(map[string]string{
+		"LANG": "en_US.UTF-8", "LC_ALL": "C"})), "LC_ALL=C")
+	assert.ErrorContains(t, checkUTF8Locale(fakeEnv(map[string]string{})),
+		"no locale")
+
+	tmpDir := t.TempDir()
+	keyPath := filepath.Join(tmpDir, KeyFile)
+	assert.ErrorContains(t, checkKey(keyPath), "run 'config'")
+	assert.NoError(t, os.WriteFile(keyPath, []byte("\n"), 0600))
+	assert.ErrorContains(t, checkKey(keyPath), "is empty")
+	assert.NoError(t, os.WriteFile(keyPath, []byte("sk-test"), 0600))
+	assert.NoError(t, checkKey(keyPath))
+
+	assert.NoError(t, os.Chmod(tmpDir, 0700))
+	assert.NoError(t, checkDir(tmpDir))
+	assert.ErrorContains(t, checkDir(filepath.Join(tmpDir, "missing")),
+		"does not exist")
+	assert.ErrorContains(t, checkDir(keyPath), "not a directory")
+	assert.NoError(t, os.Chmod(tmpDir, 0755))
+	assert.ErrorContains(t, checkDir(tmpDir), "chmod 700")
+
+	found := func(file string) (string, error) { return "/usr/bin/" + file, nil }
+	notFound := func(file string) (string, error) {
+		return "", exec.ErrNotFound
+	}
+	assert.NoError(t, checkExecutable(found, "git", "scm"))
+	assert.ErrorContains(t, checkExecutable(notFound, "git", "scm"),
+		"git was not found")
+	assert.NoError(t, checkEditor(fakeEnv(map[string]string{
+		"EDITOR": "code -w"}), found))
+	assert.ErrorContains(t, checkEditor(fakeEnv(map[string]string{}), found),
+		"$EDITOR is not set")
+	assert.ErrorContains(t, checkEditor(fakeEnv(map[string]string{
+		"EDITOR": "nano"}), notFound), "nano was not found")
+}
+
+func TestCheckPromptSize(t *testing.T) {
+	tests := []struct {
+		name       string
+		maxBytes   int
+		prompt     string
+		input      string
+		wantPrompt string
+		wantSend   bool
+	}{
+		{
+			name:       "no limit",
+			maxBytes:   0,
+			prompt:     "a long prompt",
+			wantPrompt: "a long prompt",
+			wantSend:   true,
+		},
+		{
+			name:       "below limit",
+			maxBytes:   13,
+			prompt:     "a long prompt",
+			wantPrompt: "a long prompt",
+			wantSend:   true,
+		},
+		{
+			name:       "above limit send",
+			maxBytes:   6,
+			prompt:     "a long prompt",
+			input:      "s\n",
+			wantPrompt: "a long prompt",
+			wantSend:   true,
+		},
+		{
+			name:       "above limit truncate",
+			maxBytes:   6,
+			prompt:     "a long prompt",
+			input:      "T\n",
+			wantPrompt: "a long",
+			wantSend:   true,
+		},
+		{
+			name:       "above limit truncate multibyte",
+			maxBytes:   4,
+			prompt:     "añño",
+			input:      "t\n",
+			wantPrompt: "añ",
+			wantSend:   true,
+		},
+		{
+			name:     "above limit cancel by default",
+			maxBytes: 6,
+			prompt:   "a long prompt",
+			input:    "\n",
+			wantSend: false,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			gptCliCtx := NewGptCliContext()
+			gptCliCtx.prefs.MaxPromptBytes = tt.maxBytes
+			// reading past the provided input fails, so prompts that don't
+			// require confirmation must not read at all
+			gptCliCtx.input = bufio.NewReader(strings.NewReader(tt.input))
+
+			prompt, send, err := checkPromptSize(gptCliCtx, tt.prompt)
+
+			assert.NoError(t, err)
+			assert.Equal(t, tt.wantSend, send)
+			assert.Equal(t, tt.wantPrompt, prompt)
+		})
+	}
+}
+
+func TestSessionBudget(t *testing.T) {
+	gptCliCtx := NewGptCliContext()
+	gptCliCtx.input = bufio.NewReader(strings.NewReader("n\ny\n"))
+
+	// no budget configured
+	assert.False(t, gptCliCtx.accrueUsage(openai.Usage{TotalTokens: 5000}))
+	send, err := checkBudget(gptCliCtx)
+	assert.NoError(t, err)
+	assert.True(t, send)
+
+	gptCliCtx.sessionTokens = 0
+	gptCliCtx.prefs.SessionBudgetTokens = 1000
+	gptCliCtx.prefs.BudgetWarnPercent = 50
+
+	assert.False(t, gptCliCtx.accrueUsage(openai.Usage{TotalTokens: 499}))
+	assert.True(t, gptCliCtx.accrueUsage(openai.Usage{TotalTokens: 1}))
+	// only warn once per session
+	assert.False(t, gptCliCtx.accrueUsage(openai.Usage{TotalTokens: 100}))
+	assert.Equal(t, 600, gptCliCtx.sessionTokens)
+
+	send, err = checkBudget(gptCliCtx)
+	assert.NoError(t, err)
+	assert.True(t, send)
+
+	gptCliCtx.accrueUsage(openai.Usage{TotalTokens: 400})
+	// over budget; first declined then overridden
+	send, err = checkBudget(gptCliCtx)
+	assert.NoError(t, err)
+	assert.False(t, send)
+	send, err = checkBudget(gptCliCtx)
+	assert.NoError(t, err)
+	assert.True(t, send)
+
+	prefs := Prefs{}
+	assert.Equal(t, DefaultBudgetWarnPercent, prefs.budgetWarnPercent())
+}
+
+func TestPrintStreamUsage(t *testing.T) {
+	stream := &usageChatStream{}
+	reply, usage, err := printStream(&recordingWriter{}, stream, 0)
+	assert.NoError(t, err)
+	assert.Equal(t, "hi", reply)
+	assert.Equal(t, 42, usage.TotalTokens)
+}
+
+type usageChatStream struct {
+	sent int
+}
+
+func (us *usageChatStream) Recv() (openai.ChatCompletionStreamResponse, error) {
+	us.sent++
+	switch us.sent {
+	case 1:
+		return openai.ChatCompletionStreamResponse{
+			Choices: []openai.ChatCompletionStreamChoice{
+				{Delta: openai.ChatCompletionStreamChoiceDelta{Content: "hi"}},
+			},
+		}, nil
+	case 2:
+		return openai.ChatCompletionStreamResponse{
+			Usage: &openai.Usage{TotalTokens: 42},
+		}, nil
+	}
+
+	return openai.ChatCompletionStreamResponse{}, io.EOF
+}
+
+func (us *usageChatStream) Close() error {
+	return nil
+}
+
+func TestResolveModel(t *testing.T) {
+	aliases := map[string]string{
+		"fast":    openai.GPT4oMini,
+		"quick":   "fast",
+		"default": openai.GPT4o,
+		"a":       "b",
+		"b":       "c",
+		"c":       "a",
+		"self":    "self",
+	}
+
+	model, err := resolveModel(aliases, "fast")
+	assert.NoError(t, err)
+	assert.Equal(t, openai.GPT4oMini, model)
+	model, err = resolveModel(aliases, "quick")
+	assert.NoError(t, err)
+	assert.Equal(t, openai.GPT4oMini, model)
+	model, err = resolveModel(aliases, openai.GPT4Turbo)
+	assert.NoError(t, err)
+	assert.Equal(t, openai.GPT4Turbo, model)
+	model, err = resolveModel(nil, "fast")
+	assert.NoError(t, err)
+	assert.Equal(t, "fast", model)
+
+	_, err = resolveModel(aliases, "a")
+	assert.ErrorContains(t, err, "a -> b -> c -> a")
+	_, err = resolveModel(aliases, "self")
+	assert.ErrorContains(t, err, "cycle")
+}
+
+func TestModelMain(t *testing.T) {
+	t.Setenv("HOME", t.TempDir())
+	configDir, err := getConfigDir()
+	assert.NoError(t, err)
+	assert.NoError(t, os.MkdirAll(configDir, 0700))
+
+	gptCliCtx := NewGptCliContext()
+	ctx := context.Background()
+
+	assert.NoError(t, modelMain(ctx, gptCliCtx,
+		[]string{"model", "alias", "fast", openai.GPT4oMini}))
+	assert.NoError(t, modelMain(ctx, gptCliCtx,
+		[]string{"model", "alias", "quick", "fast"}))
+	// creating a cycle is rejected and leaves the existing alias intact
+	assert.Error(t, modelMain(ctx, gptCliCtx,
+		[]string{"model", "alias", "fast", "quick"}))
+	assert.Equal(t, openai.GPT4oMini, gptCliCtx.prefs.ModelAliases["fast"])
+
+	assert.NoError(t, modelMain(ctx, gptCliCtx,
+		[]string{"model", "set", "quick"}))
+	assert.Equal(t, openai.GPT4oMini, gptCliCtx.prefs.model())
+
+	assert.NoError(t, modelMain(ctx, gptCliCtx,
+		[]string{"model", "unalias", "quick"}))
+	assert.Error(t, modelMain(ctx, gptCliCtx,
+		[]string{"model", "unalias", "quick"}))
+
+	gptCliCtx.prefs = Prefs{}
+	gptCliCtx.needConfig = false
+	assert.NoError(t, gptCliCtx.loadPrefs())
+	assert.Equal(t, openai.GPT4oMini, gptCliCtx.prefs.Model)
+	assert.Equal(t, map[string]string{"fast": openai.GPT4oMini},
+		gptCliCtx.prefs.ModelAliases)
+}
+
+func TestExpandIncludes(t *testing.T) {
+	promptsDir := t.TempDir()
+	assert.NoError(t, os.WriteFile(filepath.Join(promptsDir, "style"),
+		[]byte("Be concise. {{include:lang}}"), 0600))
+	assert.NoError(t, os.WriteFile(filepath.Join(promptsDir, "lang"),
+		[]byte("Prefer Go."), 0600))
+	assert.NoError(t, os.WriteFile(filepath.Join(promptsDir, "loop1"),
+		[]byte("{{include:loop2}}"), 0600))
+	assert.NoError(t, os.WriteFile(filepath.Join(promptsDir, "loop2"),
+		[]byte("{{include:loop1}}"), 0600))
+
+	expanded, err := expandIncludes("You are gptcli. {{include:style}}\n{{include:lang}}",
+		promptsDir, nil)
+	assert.NoError(t, err)
+	assert.Equal(t, "You are gptcli. Be concise. Prefer Go.\nPrefer Go.", expanded)
+
+	expanded, err = expandIncludes("no includes", promptsDir, nil)
+	assert.NoError(t, err)
+	assert.Equal(t, "no includes", expanded)
+
+	_, err = expandIncludes("{{include:missing}}", promptsDir, nil)
+	assert.ErrorContains(t, err, "include missing not found")
+
+	_, err = expandIncludes("{{include:loop1}}", promptsDir, nil)
+	assert.ErrorContains(t, err, "loop1 -> loop2 -> loop1")
+
+	_, err = expandIncludes("{{include:../escape}}", promptsDir, nil)
+	assert.ErrorContains(t, err, "Invalid system message include")
+}
+
+func TestExpandSystemMsgs(t *testing.T) {
+	t.Setenv("HOME", t.TempDir())
+	promptsDir, err := getPromptsDir()
+	assert.NoError(t, err)
+	assert.NoError(t, os.MkdirAll(promptsDir, 0700))
+	assert.NoError(t, os.WriteFile(filepath.Join(promptsDir, "style"),
+		[]byte("Be concise."), 0600))
+
+	dialogue := []openai.ChatCompletionMessage{
+		{Role: openai.ChatMessageRoleSystem, Content: "{{include:style}}"},
+		{Role: openai.ChatMessageRoleUser, Content: "{{include:style}}"},
+	}
+	expanded, err := expandSystemMsgs(dialogue)
+	assert.NoError(t, err)
+	assert.Equal(t, "Be concise.", expanded[0].Content)
+	// only system messages are expanded and the original is left untouched
+	assert.Equal(t, "{{include:style}}", expanded[1].Content)
+	assert.Equal(t, "{{include:style}}", dialogue[0].Content)
+
+	dialogue[0].Content = "{{include:missing}}"
+	_, err = expandSystemMsgs(dialogue)
+	assert.Error(t, err)
+}
+
+func TestQuietSuppressesRenameNotice(t *testing.T) {
+	origOutput := infoOutput
+	origQuiet := quietMode
+	defer func() {
+		infoOutput = origOutput
+		quietMode = origQuiet
+	}()
+
+	for _, quiet := range []bool{false, true} {
+		dir := t.TempDir()
+		thread := GptCliThread{
+			Name:       "quiet",
+			CreateTime: time.Now(),
+		}
+		content, err := json.Marshal(&thread)
+		assert.Nil(t, err)
+		err = os.WriteFile(filepath.Join(dir, "misnamed.json"), content, 0600)
+		assert.Nil(t, err)
+
+		var out strings.Builder
+		infoOutput = &out
+		quietMode = quiet
+
+		thrGrp := NewGptCliThreadGroup("", dir)
+		err = thrGrp.loadThreads()
+		assert.Nil(t, err)
+		assert.Equal(t, 1, len(thrGrp.threads))
+		if quiet {
+			assert.Empty(t, out.String())
+		} else {
+			assert.Contains(t, out.String(), "Renaming thread")
+		}
+	}
+}
+
+func TestSortThreadsPinnedFirst(t *testing.T) {
+	thrGrp := NewGptCliThreadGroup("", t.TempDir())
+	for idx, name := range []string{"delta", "alpha", "charlie", "bravo"} {
+		thrGrp.addThread(&GptCliThread{Name: name,
+			CreateTime: time.Unix(int64(idx), 0)})
+	}
+	thrGrp.threads[0].Pinned = true // delta
+	thrGrp.threads[2].Pinned = true // charlie
+	thrGrp.curThreadNum = 2         // alpha
+
+	byName := func(a, b *GptCliThread) bool {
+		return a.Name < b.Name
+	}
+	thrGrp.sortThreads(byName)
+
+	var names []string
+	for _, thread := range thrGrp.threads {
+		names = append(names, thread.Name)
+	}
+	assert.Equal(t, []string{"charlie", "delta", "alpha", "bravo"}, names)
+	assert.Equal(t, 3, thrGrp.curThreadNum)
+
+	// without a secondary ordering the relative order is preserved
+	thrGrp.threads[3].Pinned = true // bravo
+	thrGrp.sortThreads(nil)
+	names = nil
+	for _, thread := range thrGrp.threads {
+		names = append(names, thread.Name)
+	}
+	assert.Equal(t, []string{"charlie", "delta", "bravo", "alpha"}, names)
+
+	cells := thrGrp.threads[0].HeaderCells("1", &Prefs{})
+	assert.Equal(t, PinMarker+"charlie", cells[threadTableNameCol])
+}
+
+func TestPinThreadPersists(t *testing.T) {
+	t.Setenv("HOME", t.TempDir())
+	gptCliCtx := NewGptCliContext()
+	gptCliCtx.needConfig = false
+	thrGrp := gptCliCtx.mainThreadGroup
+	err := os.MkdirAll(thrGrp.dir, 0700)
+	assert.Nil(t, err)
+
+	cTime := time.Now()
+	for idx, name := range []string{"one", "two"} {
+		thread := &GptCliThread{
+			Name:       name,
+			CreateTime: cTime.Add(time.Duration(idx) * time.Second),
+		}
+		thread.fileName = genUniqFileName(thread.Name, thread.CreateTime)
+		assert.Nil(t, thread.save(thrGrp.dir))
+	}
+	assert.Nil(t, thrGrp.loadThreads())
+	first := thrGrp.threads[0].Name
+	second := thrGrp.threads[1].Name
+
+	ctx := context.Background()
+	err = pinThreadMain(ctx, gptCliCtx, []string{"pin", "2"})
+	assert.Nil(t, err)
+	assert.Nil(t, thrGrp.loadThreads())
+	assert.Equal(t, second, thrGrp.threads[0].Name)
+	assert.True(t, thrGrp.threads[0].Pinned)
+
+	err = unpinThreadMain(ctx, gptCliCtx, []string{"unpin", "1"})
+	assert.Nil(t, err)
+	assert.Nil(t, thrGrp.loadThreads())
+	assert.Equal(t, first, thrGrp.threads[0].Name)
+	assert.False(t, thrGrp.threads[1].Pinned)
+
+	err = pinThreadMain(ctx, gptCliCtx, []string{"pin", "3"})
+	assert.NotNil(t, err)
+}
+
+func TestLastCodeBlock(t *testing.T) {
+	dialogue := []openai.ChatCompletionMessage{
+		{Role: openai.ChatMessageRoleSystem, Content: "sys"},
+		{Role: openai.ChatMessageRoleUser, Content: "write code"},
+		{Role: openai.ChatMessageRoleAssistant,
+			Content: "first:\n```go\npackage one\n```\nsecond:\n```python\nprint('two')\n```\ndone"},
+		{Role: openai.ChatMessageRoleUser, Content: "thanks"},
+		{Role: openai.ChatMessageRoleAssistant, Content: "You're welcome"},
+	}
+
+	code, ok := lastCodeBlock(dialogue)
+	assert.True(t, ok)
+	assert.Equal(t, "print('two')\n", code)
+
+	dialogue = append(dialogue, openai.ChatCompletionMessage{
+		Role: openai.ChatMessageRoleAssistant, Content: "again:\n```\nls -l\n```"})
+	code, ok = lastCodeBlock(dialogue)
+	assert.True(t, ok)
+	assert.Equal(t, "ls -l\n", code)
+
+	_, ok = lastCodeBlock(dialogue[:2])
+	assert.False(t, ok)
+}
+
+func TestSaveCodeMain(t *testing.T) {
+	t.Setenv("HOME", t.TempDir())
+	gptCliCtx := NewGptCliContext()
+	thrGrp := gptCliCtx.mainThreadGroup
+	thrGrp.curThreadNum = thrGrp.addThread(&GptCliThread{
+		Name: "code",
+		Dialogue: []openai.ChatCompletionMessage{
+			{Role: openai.ChatMessageRoleAssistant,
+				Content: "```go\npackage main\n```"},
+		},
+	})
+	outPath := filepath.Join(t.TempDir(), "main.go")
+	ctx := context.Background()
+
+	// declining the confirmation writes nothing
+	gptCliCtx.input = bufio.NewReader(strings.NewReader(outPath + "\nn\n"))
+	err := saveCodeMain(ctx, gptCliCtx, []string{"savecode"})
+	assert.Nil(t, err)
+	_, err = os.Stat(outPath)
+	assert.True(t, os.IsNotExist(err))
+
+	gptCliCtx.input = bufio.NewReader(strings.NewReader("y\n"))
+	err = saveCodeMain(ctx, gptCliCtx, []string{"savecode", outPath})
+	assert.Nil(t, err)
+	content, err := os.ReadFile(outPath)
+	assert.Nil(t, err)
+	assert.Equal(t, "package main\n", string(content))
+}
+
+func TestApplyUserPreamble(t *testing.T) {
+	dialogue := []openai.ChatCompletionMessage{
+		{Role: openai.ChatMessageRoleSystem, Content: "sys"},
+		{Role: openai.ChatMessageRoleUser, Content: "question"},
+	}
+
+	prefs := &Prefs{}
+	assert.Equal(t, dialogue, applyUserPreamble(dialogue, prefs))
+
+	prefs.UserPreamble = "Be concise."
+	sent := applyUserPreamble(dialogue, prefs)
+	assert.Equal(t, "Be concise.\n\nquestion", sent[1].Content)
+	assert.Equal(t, "sys", sent[0].Content)
+	assert.Equal(t, "question", dialogue[1].Content)
+
+	prefs.PreamblePlacement = PreambleAppend
+	sent = applyUserPreamble(dialogue, prefs)
+	assert.Equal(t, "question\n\nBe concise.", sent[1].Content)
+	assert.Equal(t, "question", dialogue[1].Content)
+
+	prefs.PreamblePlacement = "sideways"
+	prefs.sanitize()
+	assert.Equal(t, "", prefs.PreamblePlacement)
+}
+
+func TestInteractiveThreadWorkPreamble(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	mockOpenAIClient := internal.NewMockOpenAIClient(ctrl)
+	var sentReq openai.ChatCompletionRequest
+	mockOpenAIClient.EXPECT().CreateChatCompletion(gomock.Any(), gomock.Any()).
+		DoAndReturn(func(ctx context.Context,
+			req openai.ChatCompletionRequest) (openai.ChatCompletionResponse, error) {
+
+			sentReq = req
+			return openai.ChatCompletionResponse{
+				Choices: []openai.ChatCompletionChoice{
+					{Message: openai.ChatCompletionMessage{
+						Role:    openai.ChatMessageRoleAssistant,
+						Content: "ok",
+					}},
+				},
+			}, nil
+		})
+
+	gptCliCtx := NewGptCliContext()
+	gptCliCtx.client = mockOpenAIClient
+	gptCliCtx.prefs.UserPreamble = "Be concise."
+	gptCliCtx.mainThreadGroup.dir = t.TempDir()
+	thread := &GptCliThread{
+		Name:     "preamble",
+		Dialogue: []openai.ChatCompletionMessage{},
+		fileName: "preamble.json",
+	}
+	gptCliCtx.mainThreadGroup.curThreadNum =
+		gptCliCtx.mainThreadGroup.addThread(thread)
+
+	err := interactiveThreadWork(context.Background(), gptCliCtx, "question")
+	assert.Nil(t, err)
+	assert.Equal(t, "Be concise.\n\nquestion", sentReq.Messages[0].Content)
+	assert.Equal(t, "question", thread.Dialogue[0].Content)
+}
+
+func TestSummarizePrompt(t *testing.T) {
+	prefs := &Prefs{}
+	assert.Equal(t, SummarizeMsg, prefs.summarizePrompt())
+
+	for style, instructions := range summaryStyleInstructions {
+		prefs.SummaryStyle = style
+		prompt := prefs.summarizePrompt()
+		assert.True(t, strings.HasPrefix(prompt, SummarizeMsg))
+		assert.Contains(t, prompt, instructions)
+	}
+
+	prefs.SummaryStyle = SummaryStyleBullet
+	prefs.SummaryMaxWords = 150
+	prompt := prefs.summarizePrompt()
+	assert.Contains(t, prompt, "bulleted list")
+	assert.Contains(t, prompt, "no longer than 150 words")
+
+	prefs.SummaryStyle = "haiku"
+	prefs.SummaryMaxWords = -1
+	prefs.sanitize()
+	assert.Equal(t, "", prefs.SummaryStyle)
+	assert.Equal(t, 0, prefs.SummaryMaxWords)
+}
+
+func TestNewThreadMainDuplicateName(t *testing.T) {
+	gptCliCtx := NewGptCliContext()
+	gptCliCtx.needConfig = false
+	gptCliCtx.git = fakeGit(map[string]string{})
+	thrGrp := gptCliCtx.mainThreadGroup
+	thrGrp.addThread(&GptCliThread{Name: "dup"})
+	thrGrp.addThread(&GptCliThread{Name: "dup (2)"})
+	gptCliCtx.archiveThreadGroup.addThread(&GptCliThread{Name: "archived"})
+
+	assert.True(t, thrGrp.hasThreadName("dup"))
+	assert.False(t, thrGrp.hasThreadName("archived"))
+	assert.Equal(t, "dup (3)", thrGrp.uniqThreadName("dup"))
+
+	// accept the suggested suffix, insist on the duplicate, then a name
+	// that only exists in the archive
+	gptCliCtx.input = bufio.NewReader(strings.NewReader(
+		"dup\n\ndup\nn\narchived\n"))
+	ctx := context.Background()
+	for idx := 0; idx < 3; idx++ {
+		err := newThreadMain(ctx, gptCliCtx, []string{"new"})
+		assert.NoError(t, err)
+	}
+	assert.Equal(t, "dup (3)", thrGrp.threads[2].Name)
+	assert.Equal(t, "dup", thrGrp.threads[3].Name)
+	assert.Equal(t, "archived", thrGrp.threads[4].Name)
+}
+
+func TestPrintConfigPaths(t *testing.T) {
+	homeDir := t.TempDir()
+	t.Setenv("HOME", homeDir)
+	defer setProfile("")
+
+	for _, profile := range []string{"", "work"} {
+		assert.NoError(t, setProfile(profile))
+		var out strings.Builder
+		err := printConfigPaths(&out)
+		assert.NoError(t, err)
+
+		configDir := filepath.Join(homeDir, ".config", CommandName)
+		if profile != "" {
+			configDir = filepath.Join(configDir, ProfilesDir, profile)
+		}
+		threadsDir, err := getThreadsDir()
+		assert.NoError(t, err)
+		archiveDir, err := getArchiveDir()
+		assert.NoError(t, err)
+
+		lines := strings.Split(strings.TrimSpace(out.String()), "\n")
+		assert.Equal(t, 6, len(lines))
+		assert.Equal(t, "config:  "+configDir, lines[0])
+		assert.Equal(t, "threads: "+threadsDir, lines[3])
+		assert.Equal(t, "archive: "+archiveDir, lines[4])
+		assert.True(t, strings.HasPrefix(threadsDir, configDir))
+	}
+}
+
+func TestMenuThreadSendFlow(t *testing.T) {
+	t.Setenv("HOME", t.TempDir())
+	configDir, err := getConfigDir()
+	assert.NoError(t, err)
+	assert.NoError(t, os.MkdirAll(configDir, 0700))
+	keyPath, err := getKeyPath()
+	assert.NoError(t, err)
+	assert.NoError(t, os.WriteFile(keyPath, []byte("fake-key"), 0600))
+	prefsPath, err := getPrefsPath()
+	assert.NoError(t, err)
+	assert.NoError(t, os.WriteFile(prefsPath, []byte("{}"), 0600))
+
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+	fakeClient := internal.NewMockOpenAIClient(ctrl)
+	fakeClient.EXPECT().CreateChatCompletion(gomock.Any(), gomock.Any()).
+		DoAndReturn(func(ctx context.Context,
+			req openai.ChatCompletionRequest) (openai.ChatCompletionResponse, error) {
+
+			assert.Equal(t, 2, len(req.Messages))
+			assert.Equal(t, "hello", req.Messages[1].Content)
+			return openai.ChatCompletionResponse{
+				Choices: []openai.ChatCompletionChoice{
+					{Message: openai.ChatCompletionMessage{
+						Role:    openai.ChatMessageRoleAssistant,
+						Content: "hi there",
+					}},
+				},
+			}, nil
+		})
+
+	var usedKey string
+	gptCliCtx := NewGptCliContext(
+		WithClientFactory(func(key string) internal.OpenAIClient {
+			usedKey = key
+			return fakeClient
+		}),
+		WithInput(strings.NewReader("new\nflow\nhello\nexit\nls\n")),
+	)
+	gptCliCtx.git = fakeGit(map[string]string{})
+	assert.False(t, gptCliCtx.needConfig)
+	assert.Equal(t, "fake-key", usedKey)
+	assert.NoError(t, gptCliCtx.load())
+
+	err = gptCliCtx.run(context.Background())
+	assert.ErrorIs(t, err, io.EOF)
+	assert.Equal(t, 0, gptCliCtx.mainThreadGroup.curThreadNum)
+
+	assert.NoError(t, gptCliCtx.mainThreadGroup.loadThreads())
+	assert.Equal(t, 1, len(gptCliCtx.mainThreadGroup.threads))
+	thread := gptCliCtx.mainThreadGroup.threads[0]
+	assert.Equal(t, "flow", thread.Name)
+	assert.Equal(t, 3, len(thread.Dialogue))
+	assert.Equal(t, "hi there", thread.Dialogue[2].Content)
+}
+
+func TestLoadThreadsOrder(t *testing.T) {
+	dir := t.TempDir()
+	cTime := time.Date(2024, time.November, 1, 12, 0, 0, 0, time.UTC)
+	names := []string{"zeta", "alpha", "mu", "beta", "omega", "gamma"}
+	for idx, name := range names {
+		thread := &GptCliThread{
+			Name: name,
+			// create in reverse so filename order can't match ctime order
+			CreateTime: cTime.Add(-time.Duration(idx) * time.Hour),
+			Pinned:     name == "mu",
+		}
+		thread.fileName = genUniqFileName(thread.Name, thread.CreateTime)
+		assert.NoError(t, thread.save(dir))
+	}
+	// same ctime as "gamma"; ties are broken by name
+	tied := &GptCliThread{Name: "delta", CreateTime: cTime.Add(-5 * time.Hour)}
+	tied.fileName = genUniqFileName(tied.Name, tied.CreateTime)
+	assert.NoError(t, tied.save(dir))
+
+	thrGrp := NewGptCliThreadGroup("", dir)
+	assert.NoError(t, thrGrp.loadThreads())
+	var loaded []string
+	for _, thread := range thrGrp.threads {
+		loaded = append(loaded, thread.Name)
+	}
+	assert.Equal(t,
+		[]string{"mu", "delta", "gamma", "omega", "beta", "alpha", "zeta"},
+		loaded)
+}
+
+func TestReorderThread(t *testing.T) {
+	dir := t.TempDir()
+	thrGrp := NewGptCliThreadGroup("", dir)
+	cTime := time.Date(2024, time.November, 1, 12, 0, 0, 0, time.UTC)
+	for idx, name := range []string{"pinned", "one", "two", "three", "four"} {
+		thread := &GptCliThread{
+			Name:       name,
+			CreateTime: cTime.Add(time.Duration(idx) * time.Minute),
+			Pinned:     name == "pinned",
+		}
+		thread.fileName = genUniqFileName(thread.Name, thread.CreateTime)
+		assert.NoError(t, thread.save(dir))
+	}
+	assert.NoError(t, thrGrp.loadThreads())
+	thrGrp.curThreadNum = 3 // two
+
+	names := func() []string {
+		var ret []string
+		for _, thread := range thrGrp.threads {
+			ret = append(ret, thread.Name)
+		}
+		return ret
+	}
+
+	assert.NoError(t, thrGrp.reorderThread(5, 2))
+	assert.Equal(t, []string{"pinned", "four", "one", "two", "three"}, names())
+	assert.Equal(t, 4, thrGrp.curThreadNum)
+	for idx, thread := range thrGrp.threads {
+		assert.Equal(t, idx+1, thread.Order)
+	}
+
+	assert.NoError(t, thrGrp.reorderThread(2, 4))
+	assert.Equal(t, []string{"pinned", "one", "two", "four", "three"}, names())
+
+	// the order persists and takes precedence over creation time, even for
+	// older threads without an order which sort after ordered threads
+	unordered := &GptCliThread{Name: "unordered", CreateTime: cTime.Add(-time.Hour)}
+	unordered.fileName = genUniqFileName(unordered.Name, unordered.CreateTime)
+	assert.NoError(t, unordered.save(dir))
+	assert.NoError(t, thrGrp.loadThreads())
+	assert.Equal(t,
+		[]string{"pinned", "one", "two", "four", "three", "unordered"}, names())
+
+	assert.Error(t, thrGrp.reorderThread(2, 1))
+	assert.Error(t, thrGrp.reorderThread(2, 7))
+	assert.Error(t, thrGrp.reorderThread(0, 2))
+}
+
+func TestSaveCodeMainBackup(t *testing.T) {
+	t.Setenv("HOME", t.TempDir())
+	gptCliCtx := NewGptCliContext()
+	thrGrp := gptCliCtx.mainThreadGroup
+	thrGrp.curThreadNum = thrGrp.addThread(&GptCliThread{
+		Name: "code",
+		Dialogue: []openai.ChatCompletionMessage{
+			{Role: openai.ChatMessageRoleAssistant, Content: "```\nnew\n```"},
+		},
+	})
+	outPath := filepath.Join(t.TempDir(), "out.txt")
+	backupPath := outPath + BackupSuffix
+	ctx := context.Background()
+
+	// backups are off by default
+	assert.NoError(t, os.WriteFile(outPath, []byte("first\n"), 0600))
+	gptCliCtx.input = bufio.NewReader(strings.NewReader("y\n"))
+	assert.NoError(t, saveCodeMain(ctx, gptCliCtx, []string{"savecode", outPath}))
+	_, err := os.Stat(backupPath)
+	assert.True(t, os.IsNotExist(err))
+
+	gptCliCtx.prefs.BackupOnOverwrite = true
+	assert.NoError(t, os.WriteFile(outPath, []byte("prior\n"), 0600))
+	gptCliCtx.input = bufio.NewReader(strings.NewReader("y\n"))
+	assert.NoError(t, saveCodeMain(ctx, gptCliCtx, []string{"savecode", outPath}))
+	content, err := os.ReadFile(outPath)
+	assert.NoError(t, err)
+	assert.Equal(t, "new\n", string(content))
+	content, err = os.ReadFile(backupPath)
+	assert.NoError(t, err)
+	assert.Equal(t, "prior\n", string(content))
+
+	// writing a new file creates no backup
+	newPath := filepath.Join(t.TempDir(), "fresh.txt")
+	gptCliCtx.input = bufio.NewReader(strings.NewReader("y\n"))
+	assert.NoError(t, saveCodeMain(ctx, gptCliCtx, []string{"savecode", newPath}))
+	_, err = os.Stat(newPath + BackupSuffix)
+	assert.True(t, os.IsNotExist(err))
+}
+
+// fakeReplies returns a CreateChatCompletion implementation replying to each
+// prompt with "re: <prompt>", or failing for prompts listed in failPrompts.
+func fakeReplies(failPrompts ...string) func(context.Context,
+	openai.ChatCompletionRequest) (openai.ChatCompletionResponse, error) {
+
+	return func(ctx context.Context,
+		req openai.ChatCompletionRequest) (openai.ChatCompletionResponse, error) {
+
+		prompt := req.Messages[len(req.Messages)-1].Content
+		for _, failPrompt := range failPrompts {
+			if prompt == failPrompt {
+				return openai.ChatCompletionResponse{},
+					fmt.Errorf("injected failure")
+			}
+		}
+		return openai.ChatCompletionResponse{
+			Choices: []openai.ChatCompletionChoice{
+				{Message: openai.ChatCompletionMessage{
+					Role:    openai.ChatMessageRoleAssistant,
+					Content: "re: " + prompt,
+				}},
+			},
+		}, nil
+	}
+}
+
+func TestRunBatch(t *testing.T) {
+	t.Setenv("HOME", t.TempDir())
+	batchText := "# comment\nfirst\n\nsecond\nthird\n"
+	entries, err := parseBatch(strings.NewReader(batchText))
+	assert.NoError(t, err)
+	assert.Equal(t, 3, len(entries))
+
+	tests := []struct {
+		name            string
+		continueOnError bool
+		wantOut         string
+		wantReplies     int
+	}{
+		{
+			name:            "stop",
+			continueOnError: false,
+			wantOut: ">>> first\nre: first\n\n" +
+				">>> second\n*ERROR*: injected failure\n\n",
+			wantReplies: 1,
+		},
+		{
+			name:            "continue",
+			continueOnError: true,
+			wantOut: ">>> first\nre: first\n\n" +
+				">>> second\n*ERROR*: injected failure\n\n" +
+				">>> third\nre: third\n\n",
+			wantReplies: 2,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctrl := gomock.NewController(t)
+			defer ctrl.Finish()
+			mockClient := internal.NewMockOpenAIClient(ctrl)
+			mockClient.EXPECT().CreateChatCompletion(gomock.Any(), gomock.Any()).
+				DoAndReturn(fakeReplies("second")).AnyTimes()
+
+			gptCliCtx := NewGptCliContext()
+			gptCliCtx.needConfig = false
+			gptCliCtx.client = mockClient
+			gptCliCtx.mainThreadGroup.dir = t.TempDir()
+
+			var out strings.Builder
+			err := gptCliCtx.runBatch(context.Background(), entries,
+				"batch test", &out,
+				batchOpts{continueOnError: tt.continueOnError})
+			assert.Error(t, err)
+			assert.Equal(t, tt.wantOut, out.String())
+
+			// the thread holds the successful exchanges and is saved
+			assert.NoError(t, gptCliCtx.mainThreadGroup.loadThreads())
+			thread := gptCliCtx.mainThreadGroup.threads[0]
+			assert.Equal(t, "batch test", thread.Name)
+			assert.Equal(t, 1+2*tt.wantReplies, len(thread.Dialogue))
+		})
+	}
+}
+
+func TestRunBatchResume(t *testing.T) {
+	t.Setenv("HOME", t.TempDir())
+	entries, err := parseBatch(strings.NewReader("first\nsecond\nthird\n"))
+	assert.NoError(t, err)
+	threadsDir := t.TempDir()
+	checkpointPath := filepath.Join(t.TempDir(), "batch.checkpoint")
+	opts := batchOpts{checkpointPath: checkpointPath}
+	ctx := context.Background()
+
+	// simulate an interruption by failing the second prompt
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+	failingClient := internal.NewMockOpenAIClient(ctrl)
+	failingClient.EXPECT().CreateChatCompletion(gomock.Any(), gomock.Any()).
+		DoAndReturn(fakeReplies("second")).Times(2)
+	gptCliCtx := NewGptCliContext()
+	gptCliCtx.needConfig = false
+	gptCliCtx.client = failingClient
+	gptCliCtx.mainThreadGroup.dir = threadsDir
+	var out strings.Builder
+	err = gptCliCtx.runBatch(ctx, entries, "resume", &out, opts)
+	assert.Error(t, err)
+	checkpoint, err := loadBatchCheckpoint(checkpointPath)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, checkpoint.Completed)
+
+	// resuming in a fresh session only sends the remaining prompts and
+	// continues within the same thread
+	var sent []string
+	resumeClient := internal.NewMockOpenAIClient(ctrl)
+	resumeClient.EXPECT().CreateChatCompletion(gomock.Any(), gomock.Any()).
+		DoAndReturn(func(ctx context.Context,
+			req openai.ChatCompletionRequest) (openai.ChatCompletionResponse, error) {
+
+			sent = append(sent, req.Messages[len(req.Messages)-1].Content)
+			return fakeReplies()(ctx, req)
+		}).Times(2)
+	gptCliCtx = NewGptCliContext()
+	gptCliCtx.needConfig = false
+	gptCliCtx.client = resumeClient
+	gptCliCtx.mainThreadGroup.dir = threadsDir
+	assert.NoError(t, gptCliCtx.mainThreadGroup.loadThreads())
+	out.Reset()
+	err = gptCliCtx.runBatch(ctx, entries, "resume", &out, opts)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"second", "third"}, sent)
+	assert.Equal(t,
+		">>> second\nre: second\n\n>>> third\nre: third\n\n", out.String())
+
+	assert.Equal(t, 1, len(gptCliCtx.mainThreadGroup.threads))
+	assert.Equal(t, 7, len(gptCliCtx.mainThreadGroup.threads[0].Dialogue))
+	_, err = os.Stat(checkpointPath)
+	assert.True(t, os.IsNotExist(err))
+}
+
+func TestRunBatchResumeFailed(t *testing.T) {
+	t.Setenv("HOME", t.TempDir())
+	entries, err := parseBatch(strings.NewReader("first\nsecond\n"))
+	assert.NoError(t, err)
+	threadsDir := t.TempDir()
+	checkpointPath := filepath.Join(t.TempDir(), "batch.checkpoint")
+	opts := batchOpts{continueOnError: true, checkpointPath: checkpointPath}
+	ctx := context.Background()
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	runs := []struct {
+		failPrompts  []string
+		wantSent     []string
+		wantErr      bool
+		wantFailed   []int
+		wantThreaded bool
+	}{
+		// nothing succeeds so no thread is saved or recorded
+		{[]string{"first", "second"}, []string{"first", "second"}, true,
+			[]int{0, 1}, false},
+		// only the failed prompts are retried
+		{[]string{"second"}, []string{"first", "second"}, true, []int{1},
+			true},
+		{nil, []string{"second"}, false, nil, true},
+	}
+
+	for _, run := range runs {
+		var sent []string
+		mockClient := internal.NewMockOpenAIClient(ctrl)
+		mockClient.EXPECT().CreateChatCompletion(gomock.Any(), gomock.Any()).
+			DoAndReturn(func(ctx context.Context,
+				req openai.ChatCompletionRequest) (openai.ChatCompletionResponse, error) {
+
+				sent = append(sent, req.Messages[len(req.Messages)-1].Content)
+				return fakeReplies(run.failPrompts...)(ctx, req)
+			}).Times(len(run.wantSent))
+		gptCliCtx := NewGptCliContext()
+		gptCliCtx.needConfig = false
+		gptCliCtx.client = mockClient
+		gptCliCtx.mainThreadGroup.dir = threadsDir
+		assert.NoError(t, gptCliCtx.mainThreadGroup.loadThreads())
+
+		var out strings.Builder
+		err = gptCliCtx.runBatch(ctx, entries, "retry", &out, opts)
+		assert.Equal(t, run.wantErr, err != nil)
+		assert.Equal(t, run.wantSent, sent)
+
+		checkpoint, err := loadBatchCheckpoint(checkpointPath)
+		assert.NoError(t, err)
+		assert.Equal(t, run.wantFailed, checkpoint.Failed)
+		if run.wantErr {
+			assert.Equal(t, 2, checkpoint.Completed)
+			assert.Equal(t, run.wantThreaded, checkpoint.ThreadFile != "")
+		}
+	}
+
+	// all runs continued within a single thread and the checkpoint is gone
+	// once every prompt has succeeded
+	thrGrp := NewGptCliThreadGroup("", threadsDir)
+	assert.NoError(t, thrGrp.loadThreads())
+	assert.Equal(t, 1, len(thrGrp.threads))
+	assert.Equal(t, 5, len(thrGrp.threads[0].Dialogue))
+	_, err = os.Stat(checkpointPath)
+	assert.True(t, os.IsNotExist(err))
+}
+
+func TestRunBatchIds(t *testing.T) {
+	t.Setenv("HOME", t.TempDir())
+	entries, err := parseBatch(strings.NewReader(
+		"q1\tfirst\nsecond\nq3 \t third\n"))
+	assert.NoError(t, err)
+	assert.Equal(t, []batchEntry{
+		{id: "q1", prompt: "first"},
+		{prompt: "second"},
+		{id: "q3", prompt: "third"},
+	}, entries)
+
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+	mockClient := internal.NewMockOpenAIClient(ctrl)
+	mockClient.EXPECT().CreateChatCompletion(gomock.Any(), gomock.Any()).
+		DoAndReturn(fakeReplies()).Times(3)
+	gptCliCtx := NewGptCliContext()
+	gptCliCtx.needConfig = false
+	gptCliCtx.client = mockClient
+	gptCliCtx.mainThreadGroup.dir = t.TempDir()
+
+	var out strings.Builder
+	err = gptCliCtx.runBatch(context.Background(), entries, "ids", &out,
+		batchOpts{})
+	assert.NoError(t, err)
+	assert.Equal(t, ">>> q1\tfirst\nre: first\n\n"+
+		">>> second\nre: second\n\n"+
+		">>> q3\tthird\nre: third\n\n", out.String())
+}
+
+func TestExportThreadMain(t *testing.T) {
+	t.Setenv("HOME", t.TempDir())
+	gptCliCtx := NewGptCliContext()
+	thread := &GptCliThread{
+		Name:       `say "hi"`,
+		CreateTime: time.Date(2024, time.November, 23, 21, 37, 48, 0, time.UTC),
+		Dialogue: []openai.ChatCompletionMessage{
+			{Role: openai.ChatMessageRoleSystem, Content: "system prompt"},
+			{Role: openai.ChatMessageRoleUser, Content: "print hi in go"},
+			{Role: openai.ChatMessageRoleAssistant,
+				Content: "Here you go:\n```go\nfmt.Println(\"hi\")\n```\nThat's it.\n"},
+			{Role: openai.ChatMessageRoleUser, Content: "and in sh?"},
+			{Role: openai.ChatMessageRoleAssistant,
+				Content: "```sh\necho hi\n"},
+		},
+	}
+	gptCliCtx.archiveThreadGroup.addThread(thread)
+
+	wantMarkdown := `---
+name: "say \"hi\""
+created: 2024-11-23T21:37:48Z
+---
+
+## You
+
+print hi in go
+
+## Assistant
+
+Here you go:
+` + "```go\nfmt.Println(\"hi\")\n```" + `
+That's it.
+
+## You
+
+and in sh?
+
+## Assistant
+
+` + "```sh\necho hi\n```" + `
+`
+	assert.Equal(t, wantMarkdown, thread.Markdown())
+
+	outPath := filepath.Join(t.TempDir(), "thread.md")
+	ctx := context.Background()
+	err := exportThreadMain(ctx, gptCliCtx, []string{"export", "a1", outPath})
+	assert.NoError(t, err)
+	content, err := os.ReadFile(outPath)
+	assert.NoError(t, err)
+	assert.Equal(t, wantMarkdown, string(content))
+
+	err = exportThreadMain(ctx, gptCliCtx, []string{"export", "x1", outPath})
+	assert.EqualError(t, err, fmt.Sprintf(ThreadParseErrFmt, "x1"))
+	err = exportThreadMain(ctx, gptCliCtx, []string{"export", "1", outPath})
+	assert.EqualError(t, err, fmt.Sprintf(ThreadNoExistErrFmt, "1"))
+}
+
+func TestCompareModels(t *testing.T) {
+	t.Setenv("HOME", t.TempDir())
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	mockClient := internal.NewMockOpenAIClient(ctrl)
+	sent := make(map[string][]openai.ChatCompletionMessage)
+	mockClient.EXPECT().CreateChatCompletion(gomock.Any(), gomock.Any()).
+		DoAndReturn(func(ctx context.Context,
+			req openai.ChatCompletionRequest) (openai.ChatCompletionResponse, error) {
+
+			sent[req.Model] = req.Messages
+			return openai.ChatCompletionResponse{
+				Choices: []openai.ChatCompletionChoice{
+					{Message: openai.ChatCompletionMessage{
+						Role:    openai.ChatMessageRoleAssistant,
+						Content: "from " + req.Model,
+					}},
+				},
+			}, nil
+		}).Times(2)
+
+	gptCliCtx := NewGptCliContext()
+	gptCliCtx.client = mockClient
+	gptCliCtx.prefs.Model = openai.GPT4Turbo
+	gptCliCtx.prefs.ModelAliases = map[string]string{"fast": openai.GPT4oMini}
+	gptCliCtx.prefs.CompareModels = []string{"configured-model"}
+
+	models, err := gptCliCtx.prefs.compareModelList("gpt-4o, fast")
+	assert.NoError(t, err)
+	assert.Equal(t, []string{openai.GPT4o, openai.GPT4oMini}, models)
+
+	dialogue := []openai.ChatCompletionMessage{
+		{Role: openai.ChatMessageRoleSystem, Content: "sys"},
+	}
+	replies, err := compareModels(context.Background(), gptCliCtx, dialogue,
+		"which is best?", models)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"from gpt-4o", "from gpt-4o-mini"}, replies)
+
+	assert.Equal(t, 2, len(sent))
+	for _, model := range models {
+		assert.Equal(t, []openai.ChatCompletionMessage{
+			{Role: openai.ChatMessageRoleSystem, Content: "sys"},
+			{Role: openai.ChatMessageRoleUser, Content: "which is best?"},
+		}, sent[model])
+	}
+	// global state is untouched
+	assert.Equal(t, 1, len(dialogue))
+	assert.Equal(t, openai.GPT4Turbo, gptCliCtx.prefs.Model)
+
+	models, err = gptCliCtx.prefs.compareModelList("")
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"configured-model"}, models)
+	gptCliCtx.prefs.CompareModels = nil
+	models, err = gptCliCtx.prefs.compareModelList("")
+	assert.NoError(t, err)
+	assert.Equal(t, SupportedModels, models)
+}
+
+func TestRenameThreadMain(t *testing.T) {
+	t.Setenv("HOME", t.TempDir())
+	gptCliCtx := NewGptCliContext()
+	gptCliCtx.needConfig = false
+	cTime := time.Date(2024, time.November, 1, 12, 0, 0, 0, time.UTC)
+	for _, thrGrp := range gptCliCtx.threadGroups {
+		assert.NoError(t, os.MkdirAll(thrGrp.dir, 0700))
+		for idx, name := range []string{"tpyo", "other"} {
+			thread := &GptCliThread{
+				Name:       name,
+				CreateTime: cTime.Add(time.Duration(idx) * time.Minute),
+				ModTime:    cTime,
+			}
+			thread.fileName = genUniqFileName(thread.Name, thread.CreateTime)
+			assert.NoError(t, thread.save(thrGrp.dir))
+		}
+		assert.NoError(t, thrGrp.loadThreads())
+	}
+
+	ctx := context.Background()
+	for _, threadNum := range []string{"1", "a1"} {
+		thrGrp, _, err := parseThreadNum(gptCliCtx, threadNum)
+		assert.NoError(t, err)
+		oldPath := filepath.Join(thrGrp.dir, genUniqFileName("tpyo", cTime))
+
+		err = renameThreadMain(ctx, gptCliCtx,
+			[]string{"rename", threadNum, "typo", "fixed"})
+		assert.NoError(t, err)
+		_, err = os.Stat(oldPath)
+		assert.True(t, os.IsNotExist(err))
+		_, err = os.Stat(filepath.Join(thrGrp.dir,
+			genUniqFileName("typo fixed", cTime)))
+		assert.NoError(t, err)
+
+		assert.NoError(t, thrGrp.loadThreads())
+		assert.Equal(t, 2, len(thrGrp.threads))
+		assert.Equal(t, "typo fixed", thrGrp.threads[0].Name)
+		assert.True(t, thrGrp.threads[0].ModTime.After(cTime))
+	}
+
+	// a colliding file name is refused without losing either thread
+	thrGrp := gptCliCtx.mainThreadGroup
+	colliding := &GptCliThread{Name: "clash", CreateTime: cTime}
+	colliding.fileName = genUniqFileName(colliding.Name, colliding.CreateTime)
+	assert.NoError(t, colliding.save(thrGrp.dir))
+	err := renameThreadMain(ctx, gptCliCtx, []string{"rename", "1", "clash"})
+	assert.Error(t, err)
+	assert.Equal(t, "typo fixed", thrGrp.threads[0].Name)
+	assert.NoError(t, thrGrp.loadThreads())
+	assert.Equal(t, 3, len(thrGrp.threads))
+
+	err = renameThreadMain(ctx, gptCliCtx, []string{"rename", "9", "x"})
+	assert.EqualError(t, err, fmt.Sprintf(ThreadNoExistErrFmt, "9"))
+}
+
+func TestDeleteThreadMain(t *testing.T) {
+	t.Setenv("HOME", t.TempDir())
+	gptCliCtx := NewGptCliContext()
+	gptCliCtx.needConfig = false
+	thrGrp := gptCliCtx.mainThreadGroup
+	assert.NoError(t, os.MkdirAll(thrGrp.dir, 0700))
+	cTime := time.Date(2024, time.November, 1, 12, 0, 0, 0, time.UTC)
+	for idx, name := range []string{"keep", "doomed", "current"} {
+		thread := &GptCliThread{
+			Name:       name,
+			CreateTime: cTime.Add(time.Duration(idx) * time.Minute),
+		}
+		thread.fileName = genUniqFileName(thread.Name, thread.CreateTime)
+		assert.NoError(t, thread.save(thrGrp.dir))
+	}
+	assert.NoError(t, thrGrp.loadThreads())
+	thrGrp.curThreadNum = 3
+	doomedPath := filepath.Join(thrGrp.dir,
+		genUniqFileName("doomed", cTime.Add(time.Minute)))
+	ctx := context.Background()
+
+	// declining keeps the thread
+	gptCliCtx.input = bufio.NewReader(strings.NewReader("n\ny\n"))
+	assert.NoError(t, deleteThreadMain(ctx, gptCliCtx, []string{"delete", "2"}))
+	_, err := os.Stat(doomedPath)
+	assert.NoError(t, err)
+
+	assert.NoError(t, deleteThreadMain(ctx, gptCliCtx, []string{"delete", "2"}))
+	_, err = os.Stat(doomedPath)
+	assert.True(t, os.IsNotExist(err))
+	assert.Equal(t, 2, len(thrGrp.threads))
+	assert.Equal(t, 2, thrGrp.curThreadNum)
+	assert.Equal(t, "current", thrGrp.threads[1].Name)
+
+	err = deleteThreadMain(ctx, gptCliCtx, []string{"delete", "2"})
+	assert.Error(t, err)
+	err = deleteThreadMain(ctx, gptCliCtx, []string{"delete", "5"})
+	assert.EqualError(t, err, fmt.Sprintf(ThreadNoExistErrFmt, "5"))
+	err = deleteThreadMain(ctx, gptCliCtx, []string{"delete", "a1"})
+	assert.EqualError(t, err, fmt.Sprintf(ThreadNoExistErrFmt, "a1"))
+}
+
+func TestThreadTreeOrder(t *testing.T) {
+	cTime := time.Date(2024, time.November, 1, 12, 0, 0, 0, time.UTC)
+	newThread := func(name string, minute int, parent *GptCliThread) *GptCliThread {
+		thread := &GptCliThread{
+			Name:       name,
+			CreateTime: cTime.Add(time.Duration(minute) * time.Minute),
+		}
+		if parent != nil {
+			thread.Parent = parent.id()
+		}
+		return thread
+	}
+	root := newThread("root", 0, nil)
+	other := newThread("other", 1, nil)
+	child1 := newThread("child1", 2, root)
+	grandchild := newThread("grandchild", 3, child1)
+	child2 := newThread("child2", 4, root)
+	orphan := newThread("orphan", 5, &GptCliThread{CreateTime: cTime.Add(-time.Hour)})
+	threads := []*GptCliThread{root, other, child1, grandchild, child2, orphan}
+
+	assert.Equal(t, []threadTreeNode{
+		{idx: 0, depth: 0}, // root
+		{idx: 2, depth: 1}, // child1
+		{idx: 3, depth: 2}, // grandchild
+		{idx: 4, depth: 1}, // child2
+		{idx: 1, depth: 0}, // other
+		{idx: 5, depth: 0}, // orphan
+	}, threadTreeOrder(threads))
+	// a parent link cycle doesn't hide threads
+	cycleA := newThread("a", 6, nil)
+	cycleB := newThread("b", 7, cycleA)
+	cycleA.Parent = cycleB.id()
+	assert.Equal(t, []threadTreeNode{{idx: 0, depth: 0}, {idx: 1, depth: 1}},
+		threadTreeOrder([]*GptCliThread{cycleA, cycleB}))
+
+	thrGrp := NewGptCliThreadGroup("", t.TempDir())
+	for _, thread := range threads {
+		thrGrp.addThread(thread)
+	}
+	tbl := &threadTable{elision: threadTableASCIIElision, ascii: true,
+		tree: true}
+	thrGrp.addToTable(tbl, &Prefs{}, nil)
+	var names []string
+	var nums []string
+	for _, row := range tbl.rows {
+		nums = append(nums, row[0])
+		names = append(names, row[threadTableNameCol])
+	}
+	assert.Equal(t, []string{"1", "3", "4", "5", "2", "6"}, nums)
+	assert.Equal(t, []string{"root", "`- child1", "   `- grandchild",
+		"`- child2", "other", "orphan"}, names)
+
+	tbl = &threadTable{elision: threadTableASCIIElision}
+	thrGrp.addToTable(tbl, &Prefs{}, nil)
+	assert.Equal(t, "child1", tbl.rows[2][threadTableNameCol])
+
+	// the tree glyphs don't depend on the elision marker
+	tbl = &threadTable{elision: threadTableASCIIElision, tree: true}
+	thrGrp.addToTable(tbl, &Prefs{}, nil)
+	assert.Equal(t, threadTreeBranch+"child1", tbl.rows[1][threadTableNameCol])
+	gptCliCtx := NewGptCliContext()
+	gptCliCtx.utf8Locale = true
+	gptCliCtx.prefs.ASCIIMode = true
+	assert.True(t, newThreadTable(gptCliCtx).ascii)
+}
+
+func TestMergeThreadMain(t *testing.T) {
+	t.Setenv("HOME", t.TempDir())
+	gptCliCtx := NewGptCliContext()
+	gptCliCtx.needConfig = false
+	for _, thrGrp := range gptCliCtx.threadGroups {
+		assert.NoError(t, os.MkdirAll(thrGrp.dir, 0700))
+	}
+	thrGrp := gptCliCtx.mainThreadGroup
+	cTime := time.Date(2024, time.November, 1, 12, 0, 0, 0, time.UTC)
+	dstDialogue := []openai.ChatCompletionMessage{
+		{Role: openai.ChatMessageRoleSystem, Content: "dst system"},
+		{Role: openai.ChatMessageRoleUser, Content: "dst q"},
+		{Role: openai.ChatMessageRoleAssistant, Content: "dst a"},
+	}
+	srcDialogue := []openai.ChatCompletionMessage{
+		{Role: openai.ChatMessageRoleSystem, Content: "src system"},
+		{Role: openai.ChatMessageRoleUser, Content: "src q1"},
+		{Role: openai.ChatMessageRoleAssistant, Content: "src a1"},
+		{Role: openai.ChatMessageRoleUser, Content: "src q2"},
+		{Role: openai.ChatMessageRoleAssistant, Content: "src a2"},
+	}
+	for idx, dialogue := range [][]openai.ChatCompletionMessage{
+		dstDialogue, srcDialogue} {
+
+		thread := &GptCliThread{
+			Name:            fmt.Sprintf("thread%v", idx+1),
+			CreateTime:      cTime.Add(time.Duration(idx) * time.Minute),
+			Dialogue:        dialogue,
+			SummaryDialogue: dialogue[:1],
+		}
+		thread.fileName = genUniqFileName(thread.Name, thread.CreateTime)
+		assert.NoError(t, thread.save(thrGrp.dir))
+	}
+	assert.NoError(t, thrGrp.loadThreads())
+	ctx := context.Background()
+
+	// declining leaves both threads untouched
+	gptCliCtx.input = bufio.NewReader(strings.NewReader("n\ny\n"))
+	assert.NoError(t, mergeThreadMain(ctx, gptCliCtx,
+		[]string{"merge", "--archive", "2", "1"}))
+	assert.Equal(t, 3, len(thrGrp.threads[0].Dialogue))
+
+	assert.NoError(t, mergeThreadMain(ctx, gptCliCtx,
+		[]string{"merge", "--archive", "2", "1"}))
+	assert.NoError(t, thrGrp.loadThreads())
+	assert.Equal(t, 1, len(thrGrp.threads))
+	merged := thrGrp.threads[0].Dialogue
+	var contents []string
+	for _, msg := range merged {
+		contents = append(contents, msg.Content)
+	}
+	assert.Equal(t, []string{"dst system", "dst q", "dst a", "src q1",
+		"src a1", "src q2", "src a2"}, contents)
+	assert.Empty(t, thrGrp.threads[0].SummaryDialogue)
+
+	// the source thread was archived intact
+	archive := gptCliCtx.archiveThreadGroup
+	assert.Equal(t, 1, len(archive.threads))
+	assert.Equal(t, srcDialogue, archive.threads[0].Dialogue)
+
+	err := mergeThreadMain(ctx, gptCliCtx, []string{"merge", "a1", "a1"})
+	assert.Error(t, err)
+	err = mergeThreadMain(ctx, gptCliCtx, []string{"merge", "1", "a1"})
+	assert.Error(t, err)
+	err = mergeThreadMain(ctx, gptCliCtx, []string{"merge", "1", "4"})
+	assert.EqualError(t, err, fmt.Sprintf(ThreadNoExistErrFmt, "4"))
+}
+
+func TestCloneThreadMain(t *testing.T) {
+	t.Setenv("HOME", t.TempDir())
+	gptCliCtx := NewGptCliContext()
+	gptCliCtx.needConfig = false
+	thrGrp := gptCliCtx.mainThreadGroup
+	assert.NoError(t, os.MkdirAll(thrGrp.dir, 0700))
+	cTime := time.Date(2024, time.November, 1, 12, 0, 0, 0, time.UTC)
+	index := 0
+	src := &GptCliThread{
+		Name:       "original",
+		CreateTime: cTime,
+		AccessTime: cTime,
+		ModTime:    cTime,
+		Dialogue: []openai.ChatCompletionMessage{
+			{Role: openai.ChatMessageRoleSystem, Content: "system"},
+			{Role: openai.ChatMessageRoleUser, Content: "question"},
+			{Role: openai.ChatMessageRoleAssistant, Content: "answer",
+				ToolCalls: []openai.ToolCall{{Index: &index, ID: "call"}}},
+		},
+	}
+	src.fileName = genUniqFileName(src.Name, src.CreateTime)
+	assert.NoError(t, src.save(thrGrp.dir))
+	assert.NoError(t, thrGrp.loadThreads())
+	src = thrGrp.threads[0]
+
+	gptCliCtx.input = bufio.NewReader(strings.NewReader("\n"))
+	err := cloneThreadMain(context.Background(), gptCliCtx,
+		[]string{"clone", "1"})
+	assert.NoError(t, err)
+	assert.Equal(t, 2, len(thrGrp.threads))
+	clone := thrGrp.threads[1]
+
+	assert.Equal(t, "original (clone)", clone.Name)
+	assert.Equal(t, src.Dialogue, clone.Dialogue)
+	assert.Equal(t, openai.ChatMessageRoleSystem, clone.Dialogue[0].Role)
+	assert.Equal(t, src.id(), clone.Parent)
+	assert.NotEqual(t, src.fileName, clone.fileName)
+	assert.True(t, clone.CreateTime.After(cTime))
+	assert.True(t, clone.ModTime.After(cTime))
+	assert.True(t, clone.AccessTime.After(cTime))
+
+	// edits to one thread's dialogue don't affect the other
+	clone.Dialogue[1].Content = "different question"
+	*clone.Dialogue[2].ToolCalls[0].Index = 7
+	clone.Dialogue = append(clone.Dialogue, openai.ChatCompletionMessage{
+		Role: openai.ChatMessageRoleUser, Content: "follow up"})
+	assert.Equal(t, "question", src.Dialogue[1].Content)
+	assert.Equal(t, 0, *src.Dialogue[2].ToolCalls[0].Index)
+	assert.Equal(t, 3, len(src.Dialogue))
+
+	// both threads persist, nested in the tree view
+	assert.NoError(t, thrGrp.loadThreads())
+	assert.Equal(t, 2, len(thrGrp.threads))
+	assert.Equal(t, []threadTreeNode{{idx: 0, depth: 0}, {idx: 1, depth: 1}},
+		threadTreeOrder(thrGrp.threads))
+}
+
+func TestTitleFromPrompt(t *testing.T) {
+	tests := []struct {
+		prompt string
+		want   string
+	}{
+		{"How do I reverse a slice in Go?", "How do I reverse a slice"},
+		{"Explain goroutines.", "Explain goroutines"},
+		{"\n\n  fix this bug:\n```go\nfunc main() {}\n```", "fix this bug"},
+		{"```\nonly code\n```\nwhat does it do?", "what does it do"},
+		{"Supercalifragilisticexpialidocious-and-then-some-more-text",
+			"Supercalifragilisticexpialidocious-and-t"},
+		{"internationalization localization globalization accessibility",
+			"internationalization localization"},
+		{"   ", AutoNameFallback},
+		{"?!", AutoNameFallback},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.prompt, func(t *testing.T) {
+			title := titleFromPrompt(tt.prompt)
+			assert.Equal(t, tt.want, title)
+			assert.LessOrEqual(t, utf8.RuneCountInString(title), AutoNameMaxLen)
+		})
+	}
+}
+
+func TestAutoNameThread(t *testing.T) {
+	t.Setenv("HOME", t.TempDir())
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+	mockClient := internal.NewMockOpenAIClient(ctrl)
+	mockClient.EXPECT().CreateChatCompletion(gomock.Any(), gomock.Any()).
+		DoAndReturn(fakeReplies()).Times(2)
+
+	gptCliCtx := NewGptCliContext()
+	gptCliCtx.needConfig = false
+	gptCliCtx.client = mockClient
+	gptCliCtx.git = fakeGit(map[string]string{})
+	gptCliCtx.prefs.AutoName = true
+	thrGrp := gptCliCtx.mainThreadGroup
+	assert.NoError(t, os.MkdirAll(thrGrp.dir, 0700))
+	ctx := context.Background()
+
+	gptCliCtx.input = bufio.NewReader(strings.NewReader("\n"))
+	assert.NoError(t, newThreadMain(ctx, gptCliCtx, []string{"new"}))
+	thread := thrGrp.threads[0]
+	assert.Equal(t, "", thread.Name)
+
+	err := interactiveThreadWork(ctx, gptCliCtx, "Why is the sky blue?")
+	assert.NoError(t, err)
+	assert.Equal(t, "Why is the sky blue", thread.Name)
+	// later prompts don't rename the thread
+	err = interactiveThreadWork(ctx, gptCliCtx, "And sunsets?")
+	assert.NoError(t, err)
+	assert.Equal(t, "Why is the sky blue", thread.Name)
+
+	dEntries, err := os.ReadDir(thrGrp.dir)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, len(dEntries))
+	assert.Equal(t, genUniqFileName(thread.Name, thread.CreateTime),
+		dEntries[0].Name())
+}
+
+func TestThreadSortLess(t *testing.T) {
+	base := time.Date(2024, time.November, 1, 12, 0, 0, 0, time.UTC)
+	at := func(minutes int) time.Time {
+		return base.Add(time.Duration(minutes) * time.Minute)
+	}
+	threads := []*GptCliThread{
+		{Name: "delta", CreateTime: at(0), AccessTime: at(30), ModTime: at(5)},
+		{Name: "bravo", CreateTime: at(1), AccessTime: at(10), ModTime: at(5)},
+		{Name: "alpha", CreateTime: at(2), AccessTime: at(30), ModTime: at(1)},
+		{Name: "charlie", CreateTime: at(2), AccessTime: at(20), ModTime: at(9),
+			Order: 1},
+		{Name: "alpha", CreateTime: at(3), AccessTime: at(0), ModTime: at(0),
+			Pinned: true},
+	}
+
+	tests := []struct {
+		mode  string
+		names []string
+		ctime []int
+	}{
+		{ThreadSortDefault, []string{"alpha", "charlie", "delta", "bravo", "alpha"},
+			[]int{3, 2, 0, 1, 2}},
+		{ThreadSortAccess, []string{"alpha", "alpha", "delta", "charlie", "bravo"},
+			[]int{3, 2, 0, 2, 1}},
+		{ThreadSortMod, []string{"alpha", "charlie", "bravo", "delta", "alpha"},
+			[]int{3, 2, 1, 0, 2}},
+		{ThreadSortCreate, []string{"alpha", "alpha", "charlie", "bravo", "delta"},
+			[]int{3, 2, 2, 1, 0}},
+		{ThreadSortName, []string{"alpha", "alpha", "bravo", "charlie", "delta"},
+			[]int{3, 2, 1, 2, 0}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.mode, func(t *testing.T) {
+			thrGrp := NewGptCliThreadGroup("", t.TempDir())
+			for _, thread := range threads {
+				thrGrp.addThread(thread)
+			}
+			thrGrp.sortMode = tt.mode
+			// sorting is stable and deterministic regardless of the
+			// starting order
+			for iter := 0; iter < 2; iter++ {
+				thrGrp.resort()
+				var names []string
+				var ctimes []int
+				for _, thread := range thrGrp.threads {
+					names = append(names, thread.Name)
+					ctimes = append(ctimes,
+						int(thread.CreateTime.Sub(base)/time.Minute))
+				}
+				assert.Equal(t, tt.names, names)
+				assert.Equal(t, tt.ctime, ctimes)
+				slices.Reverse(thrGrp.threads)
+			}
+		})
+	}
+}
+
+func TestSortMain(t *testing.T) {
+	t.Setenv("HOME", t.TempDir())
+	gptCliCtx := NewGptCliContext()
+	gptCliCtx.needConfig = false
+	configDir, err := getConfigDir()
+	assert.NoError(t, err)
+	assert.NoError(t, os.MkdirAll(configDir, 0700))
+	thrGrp := gptCliCtx.mainThreadGroup
+	thrGrp.addThread(&GptCliThread{Name: "zulu", CreateTime: time.Unix(1, 0)})
+	thrGrp.addThread(&GptCliThread{Name: "alpha", CreateTime: time.Unix(2, 0)})
+	thrGrp.curThreadNum = 1 // zulu
+	ctx := context.Background()
+
+	assert.NoError(t, sortMain(ctx, gptCliCtx, []string{"sort", "name"}))
+	assert.Equal(t, ThreadSortName, gptCliCtx.prefs.ThreadSort)
+	assert.Equal(t, "alpha", thrGrp.threads[0].Name)
+	assert.Equal(t, 2, thrGrp.curThreadNum)
+	assert.Equal(t, ThreadSortName, gptCliCtx.archiveThreadGroup.sortMode)
+	err = moveThreadMain(ctx, gptCliCtx, []string{"move", "1", "2"})
+	assert.Error(t, err)
+	// the manual order is left untouched rather than overwritten with the
+	// sorted positions
+	assert.Error(t, thrGrp.reorderThread(2, 1))
+	assert.Equal(t, "alpha", thrGrp.threads[0].Name)
+	for _, thread := range thrGrp.threads {
+		assert.Equal(t, 0, thread.Order)
+	}
+
+	tbl := newThreadTable(gptCliCtx)
+	thrGrp.addToTable(tbl, &gptCliCtx.prefs, nil)
+	assert.True(t, strings.HasPrefix(tbl.String(0), "Sorted by name\n"))
+
+	// the sort persists and cycles back to the default
+	var prefs Prefs
+	prefsPath, err := getPrefsPath()
+	assert.NoError(t, err)
+	content, err := os.ReadFile(prefsPath)
+	assert.NoError(t, err)
+	assert.NoError(t, json.Unmarshal(content, &prefs))
+	assert.Equal(t, ThreadSortName, prefs.ThreadSort)
+	assert.NoError(t, sortMain(ctx, gptCliCtx, []string{"sort"}))
+	assert.Equal(t, "", gptCliCtx.prefs.ThreadSort)
+	assert.Equal(t, ThreadSortDefault, thrGrp.sortMode)
+	tbl = newThreadTable(gptCliCtx)
+	assert.True(t, strings.HasPrefix(tbl.String(0), "---"))
+
+	assert.Error(t, sortMain(ctx, gptCliCtx, []string{"sort", "size"}))
+}
+
+func TestIdleSchedule(t *testing.T) {
+	tests := []struct {
+		timeout   time.Duration
+		warnAfter time.Duration
+		exitAfter time.Duration
+	}{
+		{10 * time.Minute, 9 * time.Minute, time.Minute},
+		{2 * time.Minute, time.Minute, time.Minute},
+		{time.Minute, 30 * time.Second, 30 * time.Second},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.timeout.String(), func(t *testing.T) {
+			warnAfter, exitAfter := idleSchedule(tt.timeout)
+			assert.Equal(t, tt.warnAfter, warnAfter)
+			assert.Equal(t, tt.exitAfter, exitAfter)
+			assert.Equal(t, tt.timeout, warnAfter+exitAfter)
+		})
+	}
+
+	prefs := &Prefs{IdleExitMins: -5}
+	prefs.sanitize()
+	assert.Equal(t, time.Duration(0), prefs.idleTimeout())
+}
+
+func TestReadInputIdle(t *testing.T) {
+	expired := make(chan time.Time)
+	close(expired)
+
+	tests := []struct {
+		name     string
+		idleMins int
+		// after returns the timer for the n'th wait
+		after    func(pw *io.PipeWriter, n int) <-chan time.Time
+		input    string
+		wantText string
+		wantErr  error
+		waits    int
+	}{
+		{
+			name:     "disabled",
+			idleMins: 0,
+			input:    "ls\n",
+			wantText: "ls\n",
+			waits:    0,
+		},
+		{
+			name:     "input before warning",
+			idleMins: 5,
+			after: func(pw *io.PipeWriter, n int) <-chan time.Time {
+				return nil
+			},
+			input:    "ls\n",
+			wantText: "ls\n",
+			waits:    1,
+		},
+		{
+			name:     "input after warning",
+			idleMins: 5,
+			after: func(pw *io.PipeWriter, n int) <-chan time.Time {
+				if n == 1 {
+					return expired
+				}
+				go func() {
+					_, _ = pw.Write([]byte("\n"))
+				}()
+				return nil
+			},
+			wantText: "\n",
+			waits:    2,
+		},
+		{
+			name:     "idle exit",
+			idleMins: 5,
+			after: func(pw *io.PipeWriter, n int) <-chan time.Time {
+				return expired
+			},
+			wantErr: errIdleExit,
+			waits:   2,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			pr, pw := io.Pipe()
+			defer pw.Close()
+			gptCliCtx := NewGptCliContext(WithInput(pr))
+			gptCliCtx.prefs.IdleExitMins = tt.idleMins
+			var waits []time.Duration
+			gptCliCtx.after = func(d time.Duration) <-chan time.Time {
+				waits = append(waits, d)
+				return tt.after(pw, len(waits))
+			}
+			if tt.input != "" {
+				go func() {
+					_, _ = pw.Write([]byte(tt.input))
+				}()
+			}
+
+			text, err := gptCliCtx.readInput()
+			assert.Equal(t, tt.wantErr, err)
+			assert.Equal(t, tt.wantText, text)
+			assert.Equal(t, tt.waits, len(waits))
+			if len(waits) > 0 {
+				assert.Equal(t, 4*time.Minute, waits[0])
+			}
+			if len(waits) > 1 {
+				assert.Equal(t, IdleWarnPeriod, waits[1])
+			}
+		})
+	}
+}
+
+func TestStreamOutputOption(t *testing.T) {
+	// stream output must not depend on whether the tests' stdout is a tty
+	assert.False(t, NewGptCliContext().streamOutput)
+	assert.True(t, NewGptCliContext(WithStreamOutput(true)).streamOutput)
+}
+
+func TestReplPromptTrimMode(t *testing.T) {
+	t.Setenv("HOME", t.TempDir())
+	input := "    fix this  ```\n    x := 1\n```\n"
+
+	tests := []struct {
+		trimMode   string
+		wantPrompt string
+	}{
+		{TrimModeNone, "    fix this  ```\n    x := 1\n```\n"},
+		{TrimModeEdges, "    fix this  ```\n    x := 1\n```"},
+		{TrimModeFull, "fix this  ```\n    x := 1\n```"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.trimMode, func(t *testing.T) {
+			ctrl := gomock.NewController(t)
+			defer ctrl.Finish()
+			mockClient := internal.NewMockOpenAIClient(ctrl)
+			mockClient.EXPECT().CreateChatCompletion(gomock.Any(), gomock.Any()).
+				DoAndReturn(fakeReplies())
+
+			gptCliCtx := NewGptCliContext(WithInput(strings.NewReader(input)))
+			gptCliCtx.client = mockClient
+			gptCliCtx.prefs.TrimMode = tt.trimMode
+			thrGrp := gptCliCtx.mainThreadGroup
+			thrGrp.dir = t.TempDir()
+			thread := &GptCliThread{Name: "trim", fileName: "trim.json"}
+			thrGrp.curThreadNum = thrGrp.addThread(thread)
+
+			fullCmdOrPrompt, err := getCmdOrPrompt(gptCliCtx)
+			assert.NoError(t, err)
+			err = gptCliCtx.runCommand(context.Background(), fullCmdOrPrompt)
+			assert.NoError(t, err)
+			assert.Equal(t, tt.wantPrompt, thread.Dialogue[0].Content)
+		})
+	}
+}
+
+func TestGetSubCmdAliases(t *testing.T) {
+	gptCliCtx := NewGptCliContext()
+	funcPtr := func(f func(context.Context, *GptCliContext, []string) error) uintptr {
+		return reflect.ValueOf(f).Pointer()
+	}
+
+	// every abbreviation that was unique amongst the original subcommands
+	// must continue to select the same subcommand
+	for cmd := range coreSubCommands {
+		for i := 1; i <= len(cmd); i++ {
+			prefix := cmd[:i]
+			matches := 0
+			for coreCmd := range coreSubCommands {
+				if strings.HasPrefix(coreCmd, prefix) {
+					matches++
+				}
+			}
+			if matches != 1 {
+				continue
+			}
+			subCmdFunc := gptCliCtx.getSubCmd([]string{prefix})
+			if assert.NotNil(t, subCmdFunc, prefix) {
+				assert.Equal(t, funcPtr(subCommandTab[cmd]), funcPtr(subCmdFunc),
+					prefix)
+			}
+		}
+	}
+
+	assert.Equal(t, funcPtr(exitMain), funcPtr(gptCliCtx.getSubCmd([]string{"e"})))
+	assert.Equal(t, funcPtr(exitMain), funcPtr(gptCliCtx.getSubCmd([]string{"ex"})))
+	assert.Equal(t, funcPtr(configMain), funcPtr(gptCliCtx.getSubCmd([]string{"co"})))
+	assert.Equal(t, funcPtr(unarchiveThreadMain),
+		funcPtr(gptCliCtx.getSubCmd([]string{"un"})))
+	assert.Equal(t, funcPtr(exportThreadMain),
+		funcPtr(gptCliCtx.getSubCmd([]string{"exp"})))
+	assert.Equal(t, funcPtr(unpinThreadMain), funcPtr(gptCliCtx.getSubCmd([]string{"unp"})))
+	// still ambiguous: neither or both are original subcommands
+	assert.Nil(t, gptCliCtx.getSubCmd([]string{"p"}))
+	assert.Nil(t, gptCliCtx.getSubCmd([]string{"c"}))
+}
+
+func TestRunCommandInThread(t *testing.T) {
+	t.Setenv("HOME", t.TempDir())
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	prompts := []string{
+		"delete the second paragraph",
+		"sort these numbers: 3 1 2",
+		"sort",
+		"rename the variable foo to bar",
+		"merge these two ideas",
+		"move on to the next step",
+		"export this as csv",
+		"clone the repo first",
+		"compare these two approaches",
+		"edit the intro",
+		"/etc/hosts has a typo",
+	}
+	var sent []string
+	mockClient := internal.NewMockOpenAIClient(ctrl)
+	mockClient.EXPECT().CreateChatCompletion(gomock.Any(), gomock.Any()).
+		DoAndReturn(func(ctx context.Context,
+			req openai.ChatCompletionRequest) (openai.ChatCompletionResponse, error) {
+
+			sent = append(sent, req.Messages[len(req.Messages)-1].Content)
+			return fakeReplies()(ctx, req)
+		}).Times(len(prompts))
+
+	gptCliCtx := NewGptCliContext()
+	gptCliCtx.needConfig = false
+	gptCliCtx.client = mockClient
+	thrGrp := gptCliCtx.mainThreadGroup
+	assert.NoError(t, os.MkdirAll(thrGrp.dir, 0700))
+	cTime := time.Now()
+	thread := &GptCliThread{
+		Name:       "work",
+		CreateTime: cTime,
+		fileName:   genUniqFileName("work", cTime),
+	}
+	thrGrp.curThreadNum = thrGrp.addThread(thread)
+
+	// prompts that start with a newer command's name are sent as prompts
+	ctx := context.Background()
+	for _, prompt := range prompts {
+		assert.NoError(t, gptCliCtx.runCommand(ctx, prompt), prompt)
+	}
+	assert.Equal(t, prompts, sent)
+	assert.Equal(t, "", gptCliCtx.prefs.ThreadSort)
+	assert.Equal(t, 1, len(thrGrp.threads))
+
+	// CmdPrefix runs them as commands, including by abbreviation
+	assert.NoError(t, gptCliCtx.runCommand(ctx, "/rename 1 notes"))
+	assert.Equal(t, "notes", thread.Name)
+	assert.NoError(t, gptCliCtx.runCommand(ctx, "/pi 1"))
+	assert.True(t, thread.Pinned)
+	assert.Equal(t, 1, thrGrp.curThreadNum)
+}
+
+func TestMergeThreadMainKeepsCurThread(t *testing.T) {
+	t.Setenv("HOME", t.TempDir())
+	gptCliCtx := NewGptCliContext()
+	gptCliCtx.needConfig = false
+	for _, thrGrp := range gptCliCtx.threadGroups {
+		assert.NoError(t, os.MkdirAll(thrGrp.dir, 0700))
+	}
+	thrGrp := gptCliCtx.mainThreadGroup
+	cTime := time.Date(2024, time.November, 1, 12, 0, 0, 0, time.UTC)
+	for idx, name := range []string{"src", "other", "dst"} {
+		thread := &GptCliThread{
+			Name:       name,
+			CreateTime: cTime.Add(time.Duration(idx) * time.Minute),
+			Dialogue: []openai.ChatCompletionMessage{
+				{Role: openai.ChatMessageRoleUser, Content: name + " q"},
+			},
+		}
+		thread.fileName = genUniqFileName(thread.Name, thread.CreateTime)
+		assert.NoError(t, thread.save(thrGrp.dir))
+	}
+	assert.NoError(t, thrGrp.loadThreads())
+	ctx := context.Background()
+
+	// merging from within dst leaves dst selected despite the renumbering
+	thrGrp.curThreadNum = 3
+	gptCliCtx.input = bufio.NewReader(strings.NewReader("y\n"))
+	assert.NoError(t, mergeThreadMain(ctx, gptCliCtx,
+		[]string{"merge", "--archive", "1", "3"}))
+	assert.Equal(t, 2, thrGrp.curThreadNum)
+	assert.Equal(t, "dst", thrGrp.threads[thrGrp.curThreadNum-1].Name)
+	assert.Equal(t, 2, len(thrGrp.threads[thrGrp.curThreadNum-1].Dialogue))
+
+	// merging from within src leaves no thread selected as src is archived
+	thrGrp.curThreadNum = 1
+	gptCliCtx.input = bufio.NewReader(strings.NewReader("y\n"))
+	assert.NoError(t, mergeThreadMain(ctx, gptCliCtx,
+		[]string{"merge", "--archive", "1", "2"}))
+	assert.Equal(t, 0, thrGrp.curThreadNum)
+}
+
+func TestAddThreadSorted(t *testing.T) {
+	t.Setenv("HOME", t.TempDir())
+	gptCliCtx := NewGptCliContext()
+	gptCliCtx.needConfig = false
+	gptCliCtx.git = fakeGit(map[string]string{})
+	cTime := time.Date(2024, time.November, 1, 12, 0, 0, 0, time.UTC)
+	for _, thrGrp := range gptCliCtx.threadGroups {
+		assert.NoError(t, os.MkdirAll(thrGrp.dir, 0700))
+		for idx, name := range []string{"delta", "bravo"} {
+			thread := &GptCliThread{
+				Name:       name,
+				CreateTime: cTime.Add(time.Duration(idx) * time.Minute),
+			}
+			thread.fileName = genUniqFileName(thread.Name, thread.CreateTime)
+			assert.NoError(t, thread.save(thrGrp.dir))
+		}
+	}
+	for _, thrGrp := range gptCliCtx.threadGroups {
+		assert.NoError(t, thrGrp.loadThreads())
+	}
+	gptCliCtx.prefs.ThreadSort = ThreadSortName
+	gptCliCtx.setThreadSort(ThreadSortName)
+	thrGrp := gptCliCtx.mainThreadGroup
+	names := func(thrGrp *GptCliThreadGroup) []string {
+		var names []string
+		for _, thread := range thrGrp.threads {
+			names = append(names, thread.Name)
+		}
+		return names
+	}
+	ctx := context.Background()
+
+	// new threads are placed by name and selected
+	gptCliCtx.input = bufio.NewReader(strings.NewReader("charlie\n"))
+	assert.NoError(t, newThreadMain(ctx, gptCliCtx, []string{"new"}))
+	assert.Equal(t, []string{"bravo", "charlie", "delta"}, names(thrGrp))
+	assert.Equal(t, 2, thrGrp.curThreadNum)
+
+	// as are clones, without losing the current thread
+	gptCliCtx.input = bufio.NewReader(strings.NewReader("alpha\n"))
+	assert.NoError(t, cloneThreadMain(ctx, gptCliCtx, []string{"clone", "3"}))
+	assert.Equal(t, []string{"alpha", "bravo", "charlie", "delta"},
+		names(thrGrp))
+	assert.Equal(t, "charlie", thrGrp.threads[thrGrp.curThreadNum-1].Name)
+
+	// and renamed and archived threads
+	assert.NoError(t, renameThreadMain(ctx, gptCliCtx,
+		[]string{"rename", "1", "echo"}))
+	assert.Equal(t, []string{"bravo", "charlie", "delta", "echo"},
+		names(thrGrp))
+	assert.NoError(t, archiveThreadMain(ctx, gptCliCtx,
+		[]string{"archive", "4"}))
+	assert.Equal(t, []string{"bravo", "delta", "echo"},
+		names(gptCliCtx.archiveThreadGroup))
+}
+
+func TestCompareMainPromptSize(t *testing.T) {
+	t.Setenv("HOME", t.TempDir())
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	tests := []struct {
+		answer   string
+		wantSent []string
+	}{
+		{"c", nil},
+		{"t", []string{"which", "which"}},
+		{"s", []string{"which is best?", "which is best?"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.answer, func(t *testing.T) {
+			var sent []string
+			mockClient := internal.NewMockOpenAIClient(ctrl)
+			mockClient.EXPECT().CreateChatCompletion(gomock.Any(), gomock.Any()).
+				DoAndReturn(func(ctx context.Context,
+					req openai.ChatCompletionRequest) (openai.ChatCompletionResponse, error) {
+
+					sent = append(sent, req.Messages[len(req.Messages)-1].Content)
+					return fakeReplies()(ctx, req)
+				}).Times(len(tt.wantSent))
+
+			gptCliCtx := NewGptCliContext(
+				WithInput(strings.NewReader(tt.answer + "\n")))
+			gptCliCtx.client = mockClient
+			gptCliCtx.needConfig = false
+			gptCliCtx.prefs.MaxPromptBytes = 5
+			err := compareMain(context.Background(), gptCliCtx,
+				[]string{"compare", "--models", "gpt-4o,gpt-4o-mini", "which",
+					"is", "best?"})
+			assert.NoError(t, err)
+			assert.Equal(t, tt.wantSent, sent)
+		})
+	}
+}
+
+func TestQuietSuppressesProgress(t *testing.T) {
+	t.Setenv("HOME", t.TempDir())
+	origOutput := infoOutput
+	origQuiet := quietMode
+	defer func() {
+		infoOutput = origOutput
+		quietMode = origQuiet
+	}()
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	for _, quiet := range []bool{false, true} {
+		mockClient := internal.NewMockOpenAIClient(ctrl)
+		mockClient.EXPECT().CreateChatCompletion(gomock.Any(), gomock.Any()).
+			DoAndReturn(fakeReplies()).AnyTimes()
+		gptCliCtx := NewGptCliContext()
+		gptCliCtx.needConfig = false
+		gptCliCtx.client = mockClient
+		gptCliCtx.curSummaryToggle = true
+		thrGrp := gptCliCtx.mainThreadGroup
+		thrGrp.dir = t.TempDir()
+		thread := &GptCliThread{
+			Name:     "progress",
+			fileName: "progress.json",
+			Dialogue: []openai.ChatCompletionMessage{
+				{Role: openai.ChatMessageRoleSystem, Content: "sys"},
+				{Role: openai.ChatMessageRoleUser, Content: "q"},
+				{Role: openai.ChatMessageRoleAssistant, Content: "a"},
+			},
+		}
+		thrGrp.curThreadNum = thrGrp.addThread(thread)
+
+		var out strings.Builder
+		infoOutput = &out
+		quietMode = quiet
+		ctx := context.Background()
+		assert.NoError(t, interactiveThreadWork(ctx, gptCliCtx, "next"))
+		assert.NoError(t, compareMain(ctx, gptCliCtx,
+			[]string{"compare", "--models", "gpt-4o", "which?"}))
+		if quiet {
+			assert.Empty(t, out.String())
+		} else {
+			assert.Contains(t, out.String(), "gptcli: summarizing...\n")
+			assert.Contains(t, out.String(), "gptcli: processing...\n")
+			assert.Contains(t, out.String(), "gptcli: comparing 1 models...\n")
+		}
+	}
+}
@@ -0,0 +1,133 @@
+/* Copyright © 2024 Mike Brown. All Rights Reserved.
+ *
+ * See LICENSE file at the root of this package for license terms
+ */
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/sashabaranov/go-openai"
+)
+
+// mergeDialogue returns dst's dialogue followed by src's user and assistant
+// messages. src's system messages are dropped so that dst's system message
+// continues to govern the merged thread.
+func mergeDialogue(dst []openai.ChatCompletionMessage,
+	src []openai.ChatCompletionMessage) []openai.ChatCompletionMessage {
+
+	merged := make([]openai.ChatCompletionMessage, 0, len(dst)+len(src))
+	merged = append(merged, dst...)
+	for _, msg := range src {
+		if msg.Role == openai.ChatMessageRoleSystem {
+			continue
+		}
+		merged = append(merged, msg)
+	}
+
+	return merged
+}
+
+func mergeThreadMain(ctx context.Context, gptCliCtx *GptCliContext,
+	args []string) error {
+
+	usageErr := fmt.Errorf("Syntax is 'merge [--archive] <src thread#> <dst thread#>' e.g. 'merge 2 1'\n")
+	archiveSrc := false
+	f := flag.NewFlagSet("merge", flag.ContinueOnError)
+	f.BoolVar(&archiveSrc, "archive", false,
+		"Archive the source thread once merged")
+	err := f.Parse(args[1:])
+	if err != nil {
+		return err
+	}
+	if f.NArg() != 2 {
+		return usageErr
+	}
+
+	var threads [2]*GptCliThread
+	var thrGrps [2]*GptCliThreadGroup
+	var threadNums [2]int
+	for idx, arg := range f.Args() {
+		thrGrp, threadNum, err := parseThreadNum(gptCliCtx, arg)
+		if err != nil {
+			return err
+		}
+		if threadNum > thrGrp.totThreads || threadNum == 0 {
+			return fmt.Errorf(ThreadNoExistErrFmt, arg)
+		}
+		thrGrps[idx] = thrGrp
+		threadNums[idx] = threadNum
+		threads[idx] = thrGrp.threads[threadNum-1]
+	}
+	srcArg, dstArg := f.Arg(0), f.Arg(1)
+	src, dst := threads[0], threads[1]
+	srcThrGrp, dstThrGrp := thrGrps[0], thrGrps[1]
+	if src == dst {
+		return fmt.Errorf("Cannot merge thread %v into itself\n", srcArg)
+	}
+	if dstThrGrp == gptCliCtx.archiveThreadGroup {
+		return fmt.Errorf("Cannot merge into archived thread %v; use unarchive first\n",
+			dstArg)
+	}
+	if archiveSrc && srcThrGrp == gptCliCtx.archiveThreadGroup {
+		return fmt.Errorf("Thread %v is already archived\n", srcArg)
+	}
+
+	action := ""
+	if archiveSrc {
+		action = " and then archive it"
+	}
+	fmt.Printf("Append thread %v %q to thread %v %q%v? (Y/N) [N]: ", srcArg,
+		src.Name, dstArg, dst.Name, action)
+	confirm, err := gptCliCtx.input.ReadString('\n')
+	if err != nil {
+		return err
+	}
+	confirm = strings.ToUpper(strings.TrimSpace(confirm))
+	if len(confirm) == 0 || confirm[0] != 'Y' {
+		fmt.Printf("gptcli: Threads not merged.\n")
+		return nil
+	}
+
+	dst.Dialogue = mergeDialogue(dst.Dialogue, src.Dialogue)
+	// the summary no longer reflects the merged dialogue; it will be
+	// regenerated from the full dialogue the next time it's needed
+	dst.SummaryDialogue = make([]openai.ChatCompletionMessage, 0)
+	dst.ModTime = time.Now()
+	err = dst.save(dstThrGrp.dir)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("gptcli: Merged thread %v into thread %v.\n", srcArg, dstArg)
+
+	if !archiveSrc {
+		return nil
+	}
+	// archiving deselects and renumbers threads; afterwards reselect the
+	// thread the user was in, e.g. dst, unless it was src
+	curThrGrp := gptCliCtx.curThreadGroup
+	curFileName := ""
+	if curThrGrp.curThreadNum != 0 {
+		curFileName = curThrGrp.threads[curThrGrp.curThreadNum-1].fileName
+	}
+	err = srcThrGrp.moveThread(threadNums[0], gptCliCtx.archiveThreadGroup)
+	if err != nil {
+		return fmt.Errorf("gptcli: Failed to archive thread: %w", err)
+	}
+	fmt.Printf("gptcli: Archived thread %v. Remaining threads renumbered.\n",
+		srcArg)
+	if curFileName != "" && curFileName != src.fileName {
+		curThrGrp.curThreadNum = 0
+		for idx, t := range curThrGrp.threads {
+			if t.fileName == curFileName {
+				curThrGrp.curThreadNum = idx + 1
+			}
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,93 @@
+/* Copyright © 2024 Mike Brown. All Rights Reserved.
+ *
+ * See LICENSE file at the root of this package for license terms
+ */
+package main
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// resolveModel follows user defined aliases starting at name until reaching a
+// name which isn't an alias, returning an error if the aliases form a cycle.
+func resolveModel(aliases map[string]string, name string) (string, error) {
+	seen := make(map[string]bool)
+	path := []string{name}
+	for {
+		target, ok := aliases[name]
+		if !ok {
+			return name, nil
+		}
+		if seen[name] {
+			return "", fmt.Errorf("Model alias cycle detected: %v\n",
+				strings.Join(path, " -> "))
+		}
+		seen[name] = true
+		name = target
+		path = append(path, name)
+	}
+}
+
+func modelUsageErr() error {
+	return fmt.Errorf("Syntax is 'model [set <model|alias>]', 'model alias [<alias> <model|alias>]', or 'model unalias <alias>' e.g. 'model alias fast gpt-4o-mini'\n")
+}
+
+func modelMain(ctx context.Context, gptCliCtx *GptCliContext,
+	args []string) error {
+
+	prefs := &gptCliCtx.prefs
+
+	if len(args) == 1 {
+		fmt.Printf("gptcli: Using model %v.\n", prefs.model())
+		return nil
+	}
+
+	switch {
+	case args[1] == "set" && len(args) == 3:
+		model, err := resolveModel(prefs.ModelAliases, args[2])
+		if err != nil {
+			return err
+		}
+		prefs.Model = model
+		fmt.Printf("gptcli: Using model %v.\n", model)
+	case args[1] == "alias" && len(args) == 2:
+		aliases := make([]string, 0, len(prefs.ModelAliases))
+		for alias := range prefs.ModelAliases {
+			aliases = append(aliases, alias)
+		}
+		sort.Strings(aliases)
+		for _, alias := range aliases {
+			fmt.Printf("%v -> %v\n", alias, prefs.ModelAliases[alias])
+		}
+		return nil
+	case args[1] == "alias" && len(args) == 4:
+		if prefs.ModelAliases == nil {
+			prefs.ModelAliases = make(map[string]string)
+		}
+		alias, target := args[2], args[3]
+		prevTarget, hadPrev := prefs.ModelAliases[alias]
+		prefs.ModelAliases[alias] = target
+		_, err := resolveModel(prefs.ModelAliases, alias)
+		if err != nil {
+			if hadPrev {
+				prefs.ModelAliases[alias] = prevTarget
+			} else {
+				delete(prefs.ModelAliases, alias)
+			}
+			return err
+		}
+	case args[1] == "unalias" && len(args) == 3:
+		_, ok := prefs.ModelAliases[args[2]]
+		if !ok {
+			return fmt.Errorf("Model alias %v does not exist\n", args[2])
+		}
+		delete(prefs.ModelAliases, args[2])
+	default:
+		return modelUsageErr()
+	}
+
+	return gptCliCtx.savePrefs()
+}
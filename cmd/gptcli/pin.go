@@ -0,0 +1,204 @@
+/* Copyright © 2024 Mike Brown. All Rights Reserved.
+ *
+ * See LICENSE file at the root of this package for license terms
+ */
+package main
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"sort"
+)
+
+// PinMarker prefixes the name of pinned threads in thread listings.
+const PinMarker = "* "
+
+// sortThreads orders the group's threads so that pinned threads always come
+// first. Within the pinned and unpinned sets threads are ordered by less, or
+// keep their current relative order if less is nil. The current thread, if
+// any, is renumbered so that it continues to refer to the same thread.
+func (thrGrp *GptCliThreadGroup) sortThreads(less func(a, b *GptCliThread) bool) {
+	var curThread *GptCliThread
+	if thrGrp.curThreadNum != 0 {
+		curThread = thrGrp.threads[thrGrp.curThreadNum-1]
+	}
+
+	sort.SliceStable(thrGrp.threads, func(i, j int) bool {
+		a, b := thrGrp.threads[i], thrGrp.threads[j]
+		if a.Pinned != b.Pinned {
+			return a.Pinned
+		}
+		if less == nil {
+			return false
+		}
+		return less(a, b)
+	})
+
+	if curThread == nil {
+		return
+	}
+	for idx, t := range thrGrp.threads {
+		if t == curThread {
+			thrGrp.curThreadNum = idx + 1
+			break
+		}
+	}
+}
+
+// threadCreatedBefore orders threads from oldest to newest, falling back to
+// the thread's name in order to ensure a deterministic order for threads
+// created at the same time.
+func threadCreatedBefore(a, b *GptCliThread) bool {
+	if !a.CreateTime.Equal(b.CreateTime) {
+		return a.CreateTime.Before(b.CreateTime)
+	}
+
+	return a.Name < b.Name
+}
+
+// threadOrderBefore orders threads that the user has manually reordered by
+// their order index, followed by the remaining threads from oldest to newest.
+func threadOrderBefore(a, b *GptCliThread) bool {
+	if a.Order != 0 && b.Order != 0 {
+		return a.Order < b.Order
+	}
+	if a.Order != 0 || b.Order != 0 {
+		return a.Order != 0
+	}
+
+	return threadCreatedBefore(a, b)
+}
+
+// reorderThread moves the thread numbered threadNum so that it becomes
+// thread number newThreadNum, shifting the threads in between, and records
+// the resulting order in every thread of the group. Pinned threads can only
+// be reordered amongst other pinned threads and likewise for unpinned
+// threads. Threads can only be reordered while the group uses the default
+// sort as any other sort ignores, and would then discard, the new order.
+func (thrGrp *GptCliThreadGroup) reorderThread(threadNum int,
+	newThreadNum int) error {
+
+	if thrGrp.sortMode != "" && thrGrp.sortMode != ThreadSortDefault {
+		return fmt.Errorf("Threads can only be moved when sorted by %v; use 'sort %v' first.\n",
+			threadSortDescs[ThreadSortDefault], ThreadSortDefault)
+	}
+	for _, num := range []int{threadNum, newThreadNum} {
+		if num > thrGrp.totThreads || num <= 0 {
+			threadNumPrint := fmt.Sprintf("%v%v", thrGrp.prefix, num)
+			return fmt.Errorf(ThreadNoExistErrFmt, threadNumPrint)
+		}
+	}
+	thread := thrGrp.threads[threadNum-1]
+	if thread.Pinned != thrGrp.threads[newThreadNum-1].Pinned {
+		return fmt.Errorf("Pinned threads must remain ahead of unpinned threads; use pin or unpin first.\n")
+	}
+
+	var curThread *GptCliThread
+	if thrGrp.curThreadNum != 0 {
+		curThread = thrGrp.threads[thrGrp.curThreadNum-1]
+	}
+
+	thrGrp.threads = slices.Delete(thrGrp.threads, threadNum-1, threadNum)
+	thrGrp.threads = slices.Insert(thrGrp.threads, newThreadNum-1, thread)
+
+	for idx, t := range thrGrp.threads {
+		if t == curThread {
+			thrGrp.curThreadNum = idx + 1
+		}
+		if t.Order == idx+1 {
+			continue
+		}
+		t.Order = idx + 1
+		err := t.save(thrGrp.dir)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func moveThreadMain(ctx context.Context, gptCliCtx *GptCliContext,
+	args []string) error {
+
+	usageErr := fmt.Errorf("Syntax is 'move <thread#> <new thread#>' e.g. 'move 3 1'\n")
+	if len(args) != 3 {
+		return usageErr
+	}
+	thrGrp, threadNum, err := parseThreadNum(gptCliCtx, args[1])
+	if err != nil {
+		return err
+	}
+	newThrGrp, newThreadNum, err := parseThreadNum(gptCliCtx, args[2])
+	if err != nil {
+		return err
+	}
+	if thrGrp != newThrGrp {
+		return fmt.Errorf("Threads can only be moved within a group; use archive or unarchive to move between groups.\n")
+	}
+	err = thrGrp.reorderThread(threadNum, newThreadNum)
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("gptcli: Moved thread %v to %v. Threads renumbered.\n", args[1],
+		args[2])
+
+	if gptCliCtx.curThreadGroup.curThreadNum != 0 {
+		return nil
+	}
+	lsArgs := []string{"ls"}
+	return lsThreadsMain(ctx, gptCliCtx, lsArgs)
+}
+
+func pinThreadMain(ctx context.Context, gptCliCtx *GptCliContext,
+	args []string) error {
+
+	return setThreadPinned(ctx, gptCliCtx, args, true)
+}
+
+func unpinThreadMain(ctx context.Context, gptCliCtx *GptCliContext,
+	args []string) error {
+
+	return setThreadPinned(ctx, gptCliCtx, args, false)
+}
+
+func setThreadPinned(ctx context.Context, gptCliCtx *GptCliContext,
+	args []string, pinned bool) error {
+
+	if len(args) != 2 {
+		return fmt.Errorf("Syntax is '%v <thread#>' e.g. '%v 1'\n", args[0],
+			args[0])
+	}
+	thrGrp, threadNum, err := parseThreadNum(gptCliCtx, args[1])
+	if err != nil {
+		return err
+	}
+	if threadNum > thrGrp.totThreads || threadNum == 0 {
+		return fmt.Errorf(ThreadNoExistErrFmt, args[1])
+	}
+
+	thread := thrGrp.threads[threadNum-1]
+	if thread.Pinned == pinned {
+		return nil
+	}
+	thread.Pinned = pinned
+	err = thread.save(thrGrp.dir)
+	if err != nil {
+		return err
+	}
+	thrGrp.resort()
+
+	if pinned {
+		fmt.Printf("gptcli: Pinned thread %v. Threads renumbered.\n", args[1])
+	} else {
+		fmt.Printf("gptcli: Unpinned thread %v. Threads renumbered.\n", args[1])
+	}
+
+	if gptCliCtx.curThreadGroup.curThreadNum != 0 {
+		return nil
+	}
+	lsArgs := []string{"ls"}
+	return lsThreadsMain(ctx, gptCliCtx, lsArgs)
+}
@@ -0,0 +1,41 @@
+/* Copyright © 2024 Mike Brown. All Rights Reserved.
+ *
+ * See LICENSE file at the root of this package for license terms
+ */
+package main
+
+import (
+	"github.com/sashabaranov/go-openai"
+)
+
+const (
+	PreamblePrepend = "prepend"
+	PreambleAppend  = "append"
+)
+
+// applyUserPreamble returns dialogue with the user's configured preamble
+// added to its final user message according to the configured placement. The
+// preamble only applies to the outgoing request; dialogue itself is not
+// modified so the thread's history retains the prompt as the user typed it.
+func applyUserPreamble(dialogue []openai.ChatCompletionMessage,
+	prefs *Prefs) []openai.ChatCompletionMessage {
+
+	if prefs.UserPreamble == "" || len(dialogue) == 0 {
+		return dialogue
+	}
+	lastIdx := len(dialogue) - 1
+	if dialogue[lastIdx].Role != openai.ChatMessageRoleUser {
+		return dialogue
+	}
+
+	withPreamble := append([]openai.ChatCompletionMessage{}, dialogue...)
+	if prefs.PreamblePlacement == PreambleAppend {
+		withPreamble[lastIdx].Content =
+			withPreamble[lastIdx].Content + "\n\n" + prefs.UserPreamble
+	} else {
+		withPreamble[lastIdx].Content =
+			prefs.UserPreamble + "\n\n" + withPreamble[lastIdx].Content
+	}
+
+	return withPreamble
+}
@@ -0,0 +1,98 @@
+/* Copyright © 2024 Mike Brown. All Rights Reserved.
+ *
+ * See LICENSE file at the root of this package for license terms
+ */
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+)
+
+// curProfile is the name of the active configuration profile; the empty
+// string selects the default profile which lives directly within the config
+// directory.
+var curProfile string
+
+func setProfile(name string) error {
+	if name == DefaultProfileName {
+		name = ""
+	}
+	if name == "." || name == ".." || strings.ContainsAny(name, `/\`) {
+		return fmt.Errorf("Invalid profile name %q\n", name)
+	}
+	curProfile = name
+
+	return nil
+}
+
+func profileDisplayName() string {
+	if curProfile == "" {
+		return DefaultProfileName
+	}
+
+	return curProfile
+}
+
+// switchProfile makes name the active profile, reloading the key, prefs and
+// threads from that profile's config directory.
+func (gptCliCtx *GptCliContext) switchProfile(name string) error {
+	err := setProfile(name)
+	if err != nil {
+		return err
+	}
+
+	gptCliCtx.client = nil
+	gptCliCtx.needConfig = false
+	keyText, err := loadKey()
+	if err != nil {
+		gptCliCtx.needConfig = true
+	} else {
+		gptCliCtx.client = gptCliCtx.newClient(keyText)
+	}
+	gptCliCtx.prefs = Prefs{}
+	gptCliCtx.curSummaryToggle = false
+
+	threadsDir, err := getThreadsDir()
+	if err != nil {
+		return err
+	}
+	archiveDir, err := getArchiveDir()
+	if err != nil {
+		return err
+	}
+	gptCliCtx.mainThreadGroup.dir = threadsDir
+	gptCliCtx.archiveThreadGroup.dir = archiveDir
+	for _, thrGrp := range gptCliCtx.threadGroups {
+		thrGrp.threads = make([]*GptCliThread, 0)
+		thrGrp.totThreads = 0
+		thrGrp.curThreadNum = 0
+	}
+	gptCliCtx.curThreadGroup = gptCliCtx.mainThreadGroup
+
+	return gptCliCtx.load()
+}
+
+func profileMain(ctx context.Context, gptCliCtx *GptCliContext,
+	args []string) error {
+
+	if len(args) == 1 {
+		fmt.Printf("gptcli: Using profile %v.\n", profileDisplayName())
+		return nil
+	} else if len(args) != 2 {
+		return fmt.Errorf("Syntax is 'profile [<name>]' e.g. 'profile work'\n")
+	}
+
+	err := gptCliCtx.switchProfile(args[1])
+	if err != nil {
+		return err
+	}
+	fmt.Printf("gptcli: Switched to profile %v.\n", profileDisplayName())
+	if gptCliCtx.needConfig {
+		fmt.Printf("gptcli: Profile %v is not configured yet; run 'config' to configure it.\n",
+			profileDisplayName())
+	}
+
+	return nil
+}
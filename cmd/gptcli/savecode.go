@@ -0,0 +1,149 @@
+/* Copyright © 2024 Mike Brown. All Rights Reserved.
+ *
+ * See LICENSE file at the root of this package for license terms
+ */
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/sashabaranov/go-openai"
+)
+
+// lastCodeBlock returns the contents of the final code block within the most
+// recent assistant reply of dialogue that contains one. The enclosing
+// delimiters and any language tag are stripped.
+func lastCodeBlock(dialogue []openai.ChatCompletionMessage) (string, bool) {
+	for idx := len(dialogue) - 1; idx >= 0; idx-- {
+		msg := dialogue[idx]
+		if msg.Role != openai.ChatMessageRoleAssistant {
+			continue
+		}
+
+		blocks := splitBlocks(msg.Content)
+		// splitBlocks alternates between text and code, starting with text
+		for blockIdx := len(blocks) - 1; blockIdx >= 0; blockIdx-- {
+			if blockIdx%2 == 1 {
+				return codeBlockBody(blocks[blockIdx]), true
+			}
+		}
+	}
+
+	return "", false
+}
+
+// codeBlockBody strips the delimiters and language tag from a code block as
+// returned by splitBlocks.
+func codeBlockBody(block string) string {
+	block = strings.TrimPrefix(block, CodeBlockDelim)
+	block = strings.TrimSuffix(block, CodeBlockDelim)
+	nlIdx := strings.Index(block, "\n")
+	if nlIdx == -1 {
+		return block
+	}
+	// the remainder of the opening line is the (possibly empty) language tag
+	if !strings.ContainsAny(strings.TrimSpace(block[:nlIdx]), " \t") {
+		block = block[nlIdx+1:]
+	}
+
+	return block
+}
+
+// BackupSuffix is appended to a file's name to form the name of its backup.
+const BackupSuffix = ".bak"
+
+// backupFile copies the existing file at filePath to filePath+BackupSuffix
+// prior to it being overwritten. Only the most recent backup is kept. A
+// missing filePath is not an error as there is nothing to back up.
+func backupFile(filePath string) error {
+	content, err := os.ReadFile(filePath)
+	if os.IsNotExist(err) {
+		return nil
+	} else if err != nil {
+		return fmt.Errorf("Failed to read %v for backup: %w", filePath, err)
+	}
+	info, err := os.Stat(filePath)
+	if err != nil {
+		return fmt.Errorf("Failed to stat %v for backup: %w", filePath, err)
+	}
+
+	backupPath := filePath + BackupSuffix
+	err = os.WriteFile(backupPath, content, info.Mode().Perm())
+	if err != nil {
+		return fmt.Errorf("Failed to back up %v to %v: %w", filePath,
+			backupPath, err)
+	}
+
+	return nil
+}
+
+func saveCodeMain(ctx context.Context, gptCliCtx *GptCliContext,
+	args []string) error {
+
+	if len(args) > 2 {
+		return fmt.Errorf("Syntax is 'savecode [<file>]' e.g. 'savecode main.go'\n")
+	}
+	thrGrp := gptCliCtx.curThreadGroup
+	if thrGrp.curThreadNum == 0 {
+		return fmt.Errorf("No thread is currently selected. Select one with 'thread <thread#>'.\n")
+	}
+	thread := thrGrp.threads[thrGrp.curThreadNum-1]
+	code, ok := lastCodeBlock(thread.Dialogue)
+	if !ok {
+		fmt.Printf("gptcli: No code blocks found in thread %v.\n", thread.Name)
+		return nil
+	}
+
+	var fileName string
+	if len(args) == 2 {
+		fileName = args[1]
+	} else {
+		fmt.Printf("Save code block to file: ")
+		var err error
+		fileName, err = gptCliCtx.input.ReadString('\n')
+		if err != nil {
+			return err
+		}
+	}
+	fileName = strings.TrimSpace(fileName)
+	if fileName == "" {
+		fmt.Printf("gptcli: No file given; nothing saved.\n")
+		return nil
+	}
+
+	_, err := os.Stat(fileName)
+	if err == nil {
+		fmt.Printf("%v already exists. Overwrite it with %v bytes? (Y/N) [N]: ",
+			fileName, len(code))
+	} else if os.IsNotExist(err) {
+		fmt.Printf("Write %v bytes to %v? (Y/N) [N]: ", len(code), fileName)
+	} else {
+		return fmt.Errorf("Could not stat %v: %w", fileName, err)
+	}
+	confirm, err := gptCliCtx.input.ReadString('\n')
+	if err != nil {
+		return err
+	}
+	confirm = strings.ToUpper(strings.TrimSpace(confirm))
+	if len(confirm) == 0 || confirm[0] != 'Y' {
+		fmt.Printf("gptcli: Nothing saved.\n")
+		return nil
+	}
+
+	if gptCliCtx.prefs.BackupOnOverwrite {
+		err = backupFile(fileName)
+		if err != nil {
+			return err
+		}
+	}
+	err = os.WriteFile(fileName, []byte(code), 0644)
+	if err != nil {
+		return fmt.Errorf("Failed to write %v: %w", fileName, err)
+	}
+	fmt.Printf("gptcli: Saved code block to %v.\n", fileName)
+
+	return nil
+}
@@ -0,0 +1,56 @@
+/* Copyright © 2024 Mike Brown. All Rights Reserved.
+ *
+ * See LICENSE file at the root of this package for license terms
+ */
+package main
+
+import (
+	"os/exec"
+	"path/filepath"
+	"strings"
+)
+
+// gitRunner runs git with the given arguments and returns its trimmed stdout.
+type gitRunner func(args ...string) (string, error)
+
+func runGit(args ...string) (string, error) {
+	out, err := exec.Command("git", args...).Output()
+	if err != nil {
+		return "", err
+	}
+
+	return strings.TrimSpace(string(out)), nil
+}
+
+// getRepoContext returns "<repo>@<branch>" for the git repository containing
+// the current working directory along with the repository's top level
+// directory, or two empty strings when not inside a repository. A detached
+// HEAD is reported by its abbreviated commit id instead of a branch.
+func getRepoContext(git gitRunner) (string, string) {
+	topLevel, err := git("rev-parse", "--show-toplevel")
+	if err != nil || topLevel == "" {
+		return "", ""
+	}
+	repo := filepath.Base(topLevel)
+
+	branch, err := git("symbolic-ref", "--short", "HEAD")
+	if err != nil || branch == "" {
+		branch, err = git("rev-parse", "--short", "HEAD")
+		if err != nil || branch == "" {
+			return repo, topLevel
+		}
+	}
+
+	return repo + "@" + branch, topLevel
+}
+
+// threadInRepo returns true if thread t was created within the git
+// repository whose top level directory is repoPath, regardless of branch.
+// Checkouts that merely share a directory name are different repositories.
+func threadInRepo(t *GptCliThread, repoPath string) bool {
+	if t.RepoPath == "" || repoPath == "" {
+		return false
+	}
+
+	return filepath.Clean(t.RepoPath) == filepath.Clean(repoPath)
+}
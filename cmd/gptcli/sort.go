@@ -0,0 +1,142 @@
+/* Copyright © 2024 Mike Brown. All Rights Reserved.
+ *
+ * See LICENSE file at the root of this package for license terms
+ */
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+)
+
+const (
+	ThreadSortDefault = "default"
+	ThreadSortAccess  = "access"
+	ThreadSortMod     = "mod"
+	ThreadSortCreate  = "create"
+	ThreadSortName    = "name"
+)
+
+// threadSortModes lists the supported thread sort modes in the order the
+// sort command cycles through them.
+var threadSortModes = []string{ThreadSortDefault, ThreadSortAccess,
+	ThreadSortMod, ThreadSortCreate, ThreadSortName}
+
+var threadSortDescs = map[string]string{
+	ThreadSortDefault: "creation order",
+	ThreadSortAccess:  "last accessed",
+	ThreadSortMod:     "last modified",
+	ThreadSortCreate:  "newest created",
+	ThreadSortName:    "name",
+}
+
+// threadSortLess returns the ordering for the given sort mode. The default
+// mode orders threads as they're stored: manually ordered threads followed by
+// the remainder from oldest to newest. Timestamp modes list the most recent
+// first. Ties are broken by name.
+func threadSortLess(mode string) func(a, b *GptCliThread) bool {
+	byTime := func(timeOf func(t *GptCliThread) int64) func(a,
+		b *GptCliThread) bool {
+
+		return func(a, b *GptCliThread) bool {
+			if timeOf(a) != timeOf(b) {
+				return timeOf(a) > timeOf(b)
+			}
+			return a.Name < b.Name
+		}
+	}
+
+	switch mode {
+	case ThreadSortAccess:
+		return byTime(func(t *GptCliThread) int64 {
+			return t.AccessTime.UnixNano()
+		})
+	case ThreadSortMod:
+		return byTime(func(t *GptCliThread) int64 {
+			return t.ModTime.UnixNano()
+		})
+	case ThreadSortCreate:
+		return byTime(func(t *GptCliThread) int64 {
+			return t.CreateTime.UnixNano()
+		})
+	case ThreadSortName:
+		return func(a, b *GptCliThread) bool {
+			if a.Name != b.Name {
+				return a.Name < b.Name
+			}
+			return threadCreatedBefore(a, b)
+		}
+	}
+
+	return threadOrderBefore
+}
+
+// resort orders the group's threads according to its sort mode, keeping
+// pinned threads first.
+func (thrGrp *GptCliThreadGroup) resort() {
+	thrGrp.sortThreads(threadSortLess(thrGrp.sortMode))
+}
+
+// threadSort returns the user's thread sort mode.
+func (prefs *Prefs) threadSort() string {
+	if prefs.ThreadSort == "" {
+		return ThreadSortDefault
+	}
+
+	return prefs.ThreadSort
+}
+
+// setThreadSort changes the sort mode of every thread group.
+func (gptCliCtx *GptCliContext) setThreadSort(mode string) {
+	for _, thrGrp := range gptCliCtx.threadGroups {
+		thrGrp.sortMode = mode
+		thrGrp.resort()
+	}
+}
+
+func sortMain(ctx context.Context, gptCliCtx *GptCliContext,
+	args []string) error {
+
+	if len(args) > 2 {
+		return fmt.Errorf("Syntax is 'sort [%v]' e.g. 'sort name'\n",
+			strings.Join(threadSortModes, "|"))
+	}
+
+	mode := gptCliCtx.prefs.threadSort()
+	if len(args) == 1 {
+		// cycle to the next mode
+		for idx, m := range threadSortModes {
+			if m == mode {
+				mode = threadSortModes[(idx+1)%len(threadSortModes)]
+				break
+			}
+		}
+	} else {
+		mode = args[1]
+		_, ok := threadSortDescs[mode]
+		if !ok {
+			return fmt.Errorf("Unknown sort %q; must be one of %v\n", mode,
+				strings.Join(threadSortModes, ", "))
+		}
+	}
+
+	if mode == ThreadSortDefault {
+		gptCliCtx.prefs.ThreadSort = ""
+	} else {
+		gptCliCtx.prefs.ThreadSort = mode
+	}
+	gptCliCtx.setThreadSort(mode)
+	err := gptCliCtx.savePrefs()
+	if err != nil {
+		return err
+	}
+	fmt.Printf("gptcli: Sorting threads by %v. Threads renumbered.\n",
+		threadSortDescs[mode])
+
+	if gptCliCtx.curThreadGroup.curThreadNum != 0 {
+		return nil
+	}
+	lsArgs := []string{"ls"}
+	return lsThreadsMain(ctx, gptCliCtx, lsArgs)
+}
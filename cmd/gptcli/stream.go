@@ -0,0 +1,159 @@
+/* Copyright © 2024 Mike Brown. All Rights Reserved.
+ *
+ * See LICENSE file at the root of this package for license terms
+ */
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+
+	"github.com/fatih/color"
+	"github.com/sashabaranov/go-openai"
+)
+
+// chatStream is the subset of *openai.ChatCompletionStream needed to consume
+// a streamed reply.
+type chatStream interface {
+	Recv() (openai.ChatCompletionStreamResponse, error)
+	Close() error
+}
+
+// streamPrinter writes a reply to w as it arrives, coloring text and code
+// blocks the same way as non-streamed replies. Backticks at the end of a chunk
+// are held back until the next chunk so that a code block delimiter split
+// across chunks is still recognized. Output is coalesced so that w is written
+// at most once per interval, except for the final write.
+type streamPrinter struct {
+	w         io.Writer
+	inBlock   bool
+	pending   string
+	out       strings.Builder
+	interval  time.Duration
+	lastFlush time.Time
+	now       func() time.Time
+}
+
+func newStreamPrinter(w io.Writer, interval time.Duration) *streamPrinter {
+	return &streamPrinter{
+		w:        w,
+		interval: interval,
+		now:      time.Now,
+	}
+}
+
+func (sp *streamPrinter) emit(text string) {
+	if len(text) == 0 {
+		return
+	}
+	if sp.inBlock {
+		sp.out.WriteString(color.GreenString("%v", text))
+	} else {
+		sp.out.WriteString(color.CyanString("%v", text))
+	}
+}
+
+func (sp *streamPrinter) write(chunk string) {
+	text := sp.pending + chunk
+	sp.pending = ""
+
+	idx := strings.Index(text, CodeBlockDelim)
+	for ; idx != -1; idx = strings.Index(text, CodeBlockDelim) {
+		sp.emit(text[:idx])
+		if !sp.inBlock {
+			sp.inBlock = true
+			sp.emit(CodeBlockDelim)
+		} else {
+			sp.emit(CodeBlockDelim)
+			sp.inBlock = false
+		}
+		text = text[idx+len(CodeBlockDelim):]
+	}
+
+	trimmed := strings.TrimRight(text, "`")
+	sp.pending = text[len(trimmed):]
+	sp.emit(trimmed)
+
+	now := sp.now()
+	if now.Sub(sp.lastFlush) >= sp.interval {
+		sp.flush(now)
+	}
+}
+
+func (sp *streamPrinter) finish() {
+	sp.emit(sp.pending)
+	sp.pending = ""
+	sp.out.WriteString("\n")
+	sp.flush(sp.now())
+}
+
+func (sp *streamPrinter) flush(now time.Time) {
+	sp.lastFlush = now
+	if sp.out.Len() == 0 {
+		return
+	}
+
+	fmt.Fprint(sp.w, sp.out.String())
+	sp.out.Reset()
+	flusher, ok := sp.w.(interface{ Flush() error })
+	if ok {
+		_ = flusher.Flush()
+	}
+}
+
+// printStream writes the chunks received from stream to w as they arrive,
+// no more often than once per interval, and returns the complete reply along
+// with its token usage when the stream reports it.
+func printStream(w io.Writer, stream chatStream,
+	interval time.Duration) (string, openai.Usage, error) {
+
+	var sb strings.Builder
+	var usage openai.Usage
+	sp := newStreamPrinter(w, interval)
+
+	for {
+		resp, err := stream.Recv()
+		if errors.Is(err, io.EOF) {
+			break
+		} else if err != nil {
+			sp.finish()
+			return sb.String(), usage, err
+		}
+		if resp.Usage != nil {
+			usage = *resp.Usage
+		}
+		if len(resp.Choices) == 0 {
+			continue
+		}
+		chunk := resp.Choices[0].Delta.Content
+		sb.WriteString(chunk)
+		sp.write(chunk)
+	}
+	sp.finish()
+
+	return sb.String(), usage, nil
+}
+
+// streamChat sends req and displays the reply to w incrementally as it is
+// streamed back.
+func streamChat(ctx context.Context, gptCliCtx *GptCliContext,
+	req openai.ChatCompletionRequest, w io.Writer) (string, error) {
+
+	req.Stream = true
+	req.StreamOptions = &openai.StreamOptions{IncludeUsage: true}
+	stream, err := gptCliCtx.client.CreateChatCompletionStream(ctx, req)
+	if err != nil {
+		return "", err
+	}
+	defer stream.Close()
+
+	reply, usage, err := printStream(w, stream,
+		gptCliCtx.prefs.streamFlushInterval())
+	gptCliCtx.accrueUsage(usage)
+
+	return reply, err
+}
@@ -0,0 +1,41 @@
+/* Copyright © 2024 Mike Brown. All Rights Reserved.
+ *
+ * See LICENSE file at the root of this package for license terms
+ */
+package main
+
+import (
+	"fmt"
+)
+
+const (
+	SummaryStyleShort    = "short"
+	SummaryStyleDetailed = "detailed"
+	SummaryStyleBullet   = "bullet"
+)
+
+var summaryStyleInstructions = map[string]string{
+	SummaryStyleShort: `Keep the summary brief, covering only the key
+questions, answers, and decisions.`,
+	SummaryStyleDetailed: `Preserve as much detail as possible, including
+specific facts, names, code, and any open questions.`,
+	SummaryStyleBullet: `Format the summary as a concise bulleted list with one
+point per bullet.`,
+}
+
+// summarizePrompt returns the system message used to request a summary of a
+// thread's dialogue, tailored to the user's preferred summary style and
+// length.
+func (prefs *Prefs) summarizePrompt() string {
+	prompt := SummarizeMsg
+	instructions, ok := summaryStyleInstructions[prefs.SummaryStyle]
+	if ok {
+		prompt = prompt + " " + instructions
+	}
+	if prefs.SummaryMaxWords > 0 {
+		prompt = prompt + fmt.Sprintf(" The summary should be no longer than %v words.",
+			prefs.SummaryMaxWords)
+	}
+
+	return prompt
+}
@@ -12,9 +12,11 @@ import (
 	"fmt"
 	"os"
 	"path/filepath"
+	"slices"
 	"strconv"
 	"strings"
 	"time"
+	"unicode/utf8"
 
 	"github.com/fatih/color"
 	"github.com/sashabaranov/go-openai"
@@ -27,6 +29,11 @@ type GptCliThread struct {
 	ModTime         time.Time                      `json:"mtime"`
 	Dialogue        []openai.ChatCompletionMessage `json:"dialogue"`
 	SummaryDialogue []openai.ChatCompletionMessage `json:"summary_dialogue,omitempty"`
+	Repo            string                         `json:"repo,omitempty"`
+	RepoPath        string                         `json:"repo_path,omitempty"`
+	Pinned          bool                           `json:"pinned,omitempty"`
+	Order           int                            `json:"order,omitempty"`
+	Parent          string                         `json:"parent,omitempty"`
 
 	fileName string
 }
@@ -37,6 +44,7 @@ type GptCliThreadGroup struct {
 	totThreads   int
 	dir          string
 	curThreadNum int
+	sortMode     string
 }
 
 func NewGptCliThreadGroup(prefixIn string, dirIn string) *GptCliThreadGroup {
@@ -58,7 +66,14 @@ func (thrGrp *GptCliThreadGroup) loadThreads() error {
 	thrGrp.threads = make([]*GptCliThread, 0)
 
 	dEntries, err := os.ReadDir(thrGrp.dir)
-	if err != nil {
+	if os.IsNotExist(err) {
+		// first run or the directory was removed; start with an empty group
+		err = os.MkdirAll(thrGrp.dir, 0700)
+		if err != nil {
+			return fmt.Errorf("Failed to create dir %v: %w", thrGrp.dir, err)
+		}
+		return nil
+	} else if err != nil {
 		return fmt.Errorf("Failed to read dir %v: %w", thrGrp.dir, err)
 	}
 
@@ -78,14 +93,17 @@ func (thrGrp *GptCliThreadGroup) loadThreads() error {
 		if thread.fileName != dEnt.Name() {
 			oldPath := filepath.Join(thrGrp.dir, dEnt.Name())
 			newPath := filepath.Join(thrGrp.dir, thread.fileName)
-			fmt.Fprintf(os.Stderr, "Renaming thread %v to %v\n",
-				oldPath, newPath)
+			logInfo("Renaming thread %v to %v\n", oldPath, newPath)
 			_ = os.Remove(oldPath)
 			_ = thread.save(thrGrp.dir)
 		}
 
-		_ = thrGrp.addThread(&thread)
+		thrGrp.totThreads++
+		thrGrp.threads = append(thrGrp.threads, &thread)
 	}
+	// ReadDir() returns entries in filename order, which is effectively
+	// random, so order threads explicitly instead
+	thrGrp.resort()
 
 	return nil
 }
@@ -126,71 +144,320 @@ func lsThreadsMain(ctx context.Context, gptCliCtx *GptCliContext,
 	}
 
 	showAll := false
+	repoOnly := false
+	showTree := false
+	showFlat := false
 
 	f := flag.NewFlagSet("ls", flag.ContinueOnError)
 	f.BoolVar(&showAll, "all", false, "Also show archive threads")
+	f.BoolVar(&repoOnly, "repo", false,
+		"Only show threads associated with the current git repository")
+	f.BoolVar(&showTree, "tree", false,
+		"Show cloned threads nested beneath the thread they were cloned from")
+	f.BoolVar(&showFlat, "flat", false, "Show threads without nesting")
 	err := f.Parse(args[1:])
 	if err != nil {
 		return err
 	}
 
-	fmt.Printf("%v", gptCliCtx.mainThreadGroup.String(true, !showAll))
+	var filter func(t *GptCliThread) bool
+	if repoOnly {
+		_, repoPath := getRepoContext(gptCliCtx.git)
+		if repoPath == "" {
+			return fmt.Errorf("The current directory is not within a git repository.\n")
+		}
+		filter = func(t *GptCliThread) bool {
+			return threadInRepo(t, repoPath)
+		}
+	}
+
+	tbl := newThreadTable(gptCliCtx)
+	tbl.tree = (gptCliCtx.prefs.ThreadTree || showTree) && !showFlat
+	gptCliCtx.mainThreadGroup.addToTable(tbl, &gptCliCtx.prefs, filter)
 	if showAll {
-		fmt.Printf("%v", gptCliCtx.archiveThreadGroup.String(false, true))
+		gptCliCtx.archiveThreadGroup.addToTable(tbl, &gptCliCtx.prefs, filter)
 	}
+	fmt.Printf("%v", tbl.String(getTermWidth()))
 
 	return nil
 }
 
-func threadGroupHeaderString() string {
+// threadTable accumulates the rows of a thread listing so that its column
+// widths can be sized to fit both the data and the terminal before rendering.
+type threadTable struct {
+	rows    [][]string
+	elision string
+	// ascii selects plain ASCII tree glyphs for terminals that can't render
+	// box drawing characters
+	ascii    bool
+	tree     bool
+	sortMode string
+}
+
+func newThreadTable(gptCliCtx *GptCliContext) *threadTable {
+	ascii := gptCliCtx.asciiGlyphs()
+
+	return &threadTable{
+		elision:  selectElision(!ascii),
+		ascii:    ascii,
+		sortMode: gptCliCtx.prefs.threadSort(),
+	}
+}
+
+// selectElision returns the marker used for elided names; terminals without a
+// UTF-8 locale can't render "…" so fall back to plain ASCII.
+func selectElision(utf8Locale bool) string {
+	if utf8Locale {
+		return threadTableElision
+	}
+
+	return threadTableASCIIElision
+}
+
+var threadTableHeader = []string{"Thread#", "Last Accessed", "Last Modified",
+	"Created", "Name"}
+
+const (
+	threadTableNameCol      = 4
+	threadTableMinNameWidth = 8
+	threadTableElision      = "…"
+	threadTableASCIIElision = "..."
+)
+
+func (tbl *threadTable) elisionMarker() string {
+	if tbl.elision == "" {
+		return threadTableElision
+	}
+
+	return tbl.elision
+}
+
+func (tbl *threadTable) addRow(cells []string) {
+	tbl.rows = append(tbl.rows, cells)
+}
+
+// computeColumnWidths returns the width of each column needed to display rows
+// along with the header. If termWidth is positive and the resulting table
+// would not fit, the Name column is narrowed (to no less than
+// threadTableMinNameWidth) so that it does.
+func computeColumnWidths(rows [][]string, termWidth int) []int {
+	widths := make([]int, len(threadTableHeader))
+	for idx, cell := range threadTableHeader {
+		widths[idx] = utf8.RuneCountInString(cell)
+	}
+	for _, row := range rows {
+		for idx, cell := range row {
+			widths[idx] = max(widths[idx], utf8.RuneCountInString(cell))
+		}
+	}
+
+	if termWidth <= 0 {
+		return widths
+	}
+	total := tableWidth(widths)
+	if total > termWidth {
+		widths[threadTableNameCol] = max(threadTableMinNameWidth,
+			widths[threadTableNameCol]-(total-termWidth))
+	}
+
+	return widths
+}
+
+// tableWidth returns the total rendered width of a row given its column
+// widths, e.g. "| a | b | name".
+func tableWidth(widths []int) int {
+	total := 2
+	for _, w := range widths {
+		total += w
+	}
+
+	return total + 3*(len(widths)-1)
+}
+
+// elide shortens s to at most width runes, replacing the tail with elision
+// when it doesn't fit.
+func elide(s string, width int, elision string) string {
+	runes := []rune(s)
+	if len(runes) <= width {
+		return s
+	}
+	elisionLen := utf8.RuneCountInString(elision)
+	if width < elisionLen {
+		return string(runes[:max(width, 0)])
+	}
+
+	return string(runes[:width-elisionLen]) + elision
+}
+
+func (tbl *threadTable) formatRow(sb *strings.Builder, cells []string,
+	widths []int) {
+
+	sb.WriteString("|")
+	for idx, cell := range cells {
+		if idx == threadTableNameCol {
+			sb.WriteString(fmt.Sprintf(" %-*v\n", widths[idx],
+				elide(cell, widths[idx], tbl.elisionMarker())))
+		} else {
+			sb.WriteString(fmt.Sprintf(" %*v |", widths[idx], cell))
+		}
+	}
+}
+
+// String renders the table sized to fit within termWidth columns; a
+// non-positive termWidth disables fitting to the terminal.
+func (tbl *threadTable) String(termWidth int) string {
 	var sb strings.Builder
 
-	sb.WriteString(RowSpacer)
-	sb.WriteString(fmt.Sprintf(RowFmt, "Thread#", "Last Accessed", "Last Modified",
-		"Created", "Name"))
+	widths := computeColumnWidths(tbl.rows, termWidth)
+	spacer := strings.Repeat("-", tableWidth(widths)) + "\n"
 
-	sb.WriteString(RowSpacer)
+	if tbl.sortMode != "" && tbl.sortMode != ThreadSortDefault {
+		sb.WriteString(fmt.Sprintf("Sorted by %v\n",
+			threadSortDescs[tbl.sortMode]))
+	}
+	sb.WriteString(spacer)
+	tbl.formatRow(&sb, threadTableHeader, widths)
+	sb.WriteString(spacer)
+	for _, row := range tbl.rows {
+		tbl.formatRow(&sb, row, widths)
+	}
+	sb.WriteString(spacer)
 
 	return sb.String()
 }
 
-func threadGroupFooterString() string {
-	return RowSpacer
+// formatHeaderTime renders a thread timestamp for the thread listing. When
+// relative dates are enabled (the default), timestamps from today or
+// yesterday have their date replaced with "Today" or "Yesterday" and keep
+// the time of day portion of the configured layout.
+func formatHeaderTime(t time.Time, now time.Time, prefs *Prefs) string {
+	layout := HeaderTimeFmt
+	timeOfDayLayout := HeaderTimeOfDayFmt
+	if prefs.Use24HourClock {
+		layout = HeaderTime24Fmt
+		timeOfDayLayout = HeaderTimeOfDay24Fmt
+	}
+	if prefs.DateFormat != "" {
+		layout = prefs.DateFormat
+		timeOfDayLayout = timeOfDayPart(layout)
+	}
+	t = t.In(now.Location())
+	if prefs.NoRelativeDates {
+		return t.Format(layout)
+	}
+
+	var day string
+	if isSameDay(t, now) {
+		day = "Today"
+	} else if isSameDay(t, now.AddDate(0, 0, -1)) {
+		day = "Yesterday"
+	} else {
+		return t.Format(layout)
+	}
+	if timeOfDayLayout == "" {
+		return day
+	}
+
+	return day + " " + t.Format(timeOfDayLayout)
 }
 
-func (t *GptCliThread) HeaderString(threadNum string) string {
-	cTime := t.CreateTime.Format("01/02/2006 03:04pm")
-	aTime := t.AccessTime.Format("01/02/2006 03:04pm")
-	mTime := t.ModTime.Format("01/02/2006 03:04pm")
-	today := time.Now().UTC().Truncate(24 * time.Hour).Format("01/02/2006")
-	yesterday := time.Now().UTC().Add(-24 * time.Hour).Truncate(24 * time.Hour).Format("01/02/2006")
-	cTime = strings.ReplaceAll(cTime, today, "Today")
-	aTime = strings.ReplaceAll(aTime, today, "Today")
-	mTime = strings.ReplaceAll(mTime, today, "Today")
-	cTime = strings.ReplaceAll(cTime, yesterday, "Yesterday")
-	aTime = strings.ReplaceAll(aTime, yesterday, "Yesterday")
-	mTime = strings.ReplaceAll(mTime, yesterday, "Yesterday")
+// timeOfDayPart returns the portion of a Go time layout that spans from its
+// hour element through its last minute, second, or AM/PM element, e.g.
+// "15:04" for "2006-01-02 15:04". It returns "" if layout has no hour.
+func timeOfDayPart(layout string) string {
+	start, end := -1, -1
+	for _, hour := range []string{"15", "03", "3"} {
+		idx := strings.Index(layout, hour)
+		if idx != -1 && (start == -1 || idx < start) {
+			start, end = idx, idx+len(hour)
+		}
+	}
+	if start == -1 {
+		return ""
+	}
+
+	for _, elem := range []string{"04", "05", "PM", "pm"} {
+		idx := strings.LastIndex(layout, elem)
+		if idx >= start && idx+len(elem) > end {
+			end = idx + len(elem)
+		}
+	}
 
-	return fmt.Sprintf(RowFmt, threadNum, aTime, mTime, cTime, t.Name)
+	return layout[start:end]
 }
 
-func (thrGrp *GptCliThreadGroup) String(header bool, footer bool) string {
-	var sb strings.Builder
+func isSameDay(t1 time.Time, t2 time.Time) bool {
+	y1, m1, d1 := t1.Date()
+	y2, m2, d2 := t2.Date()
+
+	return y1 == y2 && m1 == m2 && d1 == d2
+}
+
+// humanizeDuration renders d as a short relative duration such as "3h ago".
+func humanizeDuration(d time.Duration) string {
+	if d < 0 {
+		d = 0
+	}
 
-	if header {
-		sb.WriteString(threadGroupHeaderString())
+	switch {
+	case d < time.Minute:
+		return fmt.Sprintf("%vs ago", int(d/time.Second))
+	case d < time.Hour:
+		return fmt.Sprintf("%vm ago", int(d/time.Minute))
+	case d < 24*time.Hour:
+		return fmt.Sprintf("%vh ago", int(d/time.Hour))
 	}
 
-	for idx, t := range thrGrp.threads {
-		threadNum := fmt.Sprintf("%v%v", thrGrp.prefix, idx+1)
-		sb.WriteString(t.HeaderString(threadNum))
+	return fmt.Sprintf("%vd ago", int(d/(24*time.Hour)))
+}
+
+func (t *GptCliThread) HeaderCells(threadNum string, prefs *Prefs) []string {
+	now := time.Now().In(prefs.location())
+	cTime := formatHeaderTime(t.CreateTime, now, prefs)
+	var aTime, mTime string
+	if prefs.TimeAgo {
+		aTime = humanizeDuration(now.Sub(t.AccessTime))
+		mTime = humanizeDuration(now.Sub(t.ModTime))
+	} else {
+		aTime = formatHeaderTime(t.AccessTime, now, prefs)
+		mTime = formatHeaderTime(t.ModTime, now, prefs)
 	}
 
-	if footer {
-		sb.WriteString(threadGroupFooterString())
+	name := t.Name
+	if t.Pinned {
+		name = PinMarker + name
 	}
 
-	return sb.String()
+	return []string{threadNum, aTime, mTime, cTime, name}
+}
+
+// addToTable adds a row to tbl for each thread in the group. If filter is
+// non-nil only threads for which it returns true are added; thread numbers
+// are unaffected by filtering. If tbl is a tree listing, cloned threads are
+// nested beneath their parent.
+func (thrGrp *GptCliThreadGroup) addToTable(tbl *threadTable, prefs *Prefs,
+	filter func(t *GptCliThread) bool) {
+
+	nodes := make([]threadTreeNode, 0, len(thrGrp.threads))
+	if tbl.tree {
+		nodes = threadTreeOrder(thrGrp.threads)
+	} else {
+		for idx := range thrGrp.threads {
+			nodes = append(nodes, threadTreeNode{idx: idx})
+		}
+	}
+
+	for _, node := range nodes {
+		t := thrGrp.threads[node.idx]
+		if filter != nil && !filter(t) {
+			continue
+		}
+		threadNum := fmt.Sprintf("%v%v", thrGrp.prefix, node.idx+1)
+		cells := t.HeaderCells(threadNum, prefs)
+		cells[threadTableNameCol] = tbl.treePrefix(node.depth) +
+			cells[threadTableNameCol]
+		tbl.addRow(cells)
+	}
 }
 
 func parseThreadNum(gptCliCtx *GptCliContext,
@@ -282,17 +549,31 @@ func newThreadMain(ctx context.Context, gptCliCtx *GptCliContext,
 		return fmt.Errorf("You must run 'config' before creating a thread.\n")
 	}
 
-	fmt.Printf("Enter new thread's name: ")
+	repo, repoPath := getRepoContext(gptCliCtx.git)
+	if gptCliCtx.prefs.AutoName {
+		fmt.Printf("Enter new thread's name [named from first prompt]: ")
+	} else if repo != "" {
+		fmt.Printf("Enter new thread's name [%v]: ", repo)
+	} else {
+		fmt.Printf("Enter new thread's name: ")
+	}
 	name, err := gptCliCtx.input.ReadString('\n')
 	if err != nil {
 		return err
 	}
 	name = strings.TrimSpace(name)
+	if name == "" && !gptCliCtx.prefs.AutoName {
+		name = repo
+	}
+	name, err = confirmThreadName(gptCliCtx, gptCliCtx.mainThreadGroup, name)
+	if err != nil {
+		return err
+	}
 	cTime := time.Now()
 	fileName := genUniqFileName(name, cTime)
 
 	dialogue := []openai.ChatCompletionMessage{
-		{Role: openai.ChatMessageRoleSystem, Content: SystemMsg},
+		{Role: openai.ChatMessageRoleSystem, Content: gptCliCtx.prefs.systemMsg()},
 	}
 
 	curThread := &GptCliThread{
@@ -302,6 +583,8 @@ func newThreadMain(ctx context.Context, gptCliCtx *GptCliContext,
 		ModTime:         cTime,
 		Dialogue:        dialogue,
 		SummaryDialogue: make([]openai.ChatCompletionMessage, 0),
+		Repo:            repo,
+		RepoPath:        repoPath,
 		fileName:        fileName,
 	}
 	gptCliCtx.mainThreadGroup.curThreadNum =
@@ -310,11 +593,61 @@ func newThreadMain(ctx context.Context, gptCliCtx *GptCliContext,
 	return nil
 }
 
+// hasThreadName returns true if any thread in the group is named name.
+func (thrGrp *GptCliThreadGroup) hasThreadName(name string) bool {
+	for _, t := range thrGrp.threads {
+		if t.Name == name {
+			return true
+		}
+	}
+
+	return false
+}
+
+// uniqThreadName returns name with the smallest numeric suffix, e.g.
+// "name (2)", that is not already in use within the group.
+func (thrGrp *GptCliThreadGroup) uniqThreadName(name string) string {
+	for suffix := 2; ; suffix++ {
+		candidate := fmt.Sprintf("%v (%v)", name, suffix)
+		if !thrGrp.hasThreadName(candidate) {
+			return candidate
+		}
+	}
+}
+
+// confirmThreadName warns the user when name is already used by another
+// thread in thrGrp and offers to disambiguate it with a suffix. The user may
+// decline in order to keep the duplicate name.
+func confirmThreadName(gptCliCtx *GptCliContext, thrGrp *GptCliThreadGroup,
+	name string) (string, error) {
+
+	if name == "" || !thrGrp.hasThreadName(name) {
+		return name, nil
+	}
+
+	uniqName := thrGrp.uniqThreadName(name)
+	fmt.Printf("A thread named %q already exists. Use %q instead? (Y/N) [Y]: ",
+		name, uniqName)
+	useUniq, err := gptCliCtx.input.ReadString('\n')
+	if err != nil {
+		return "", err
+	}
+	useUniq = strings.ToUpper(strings.TrimSpace(useUniq))
+	if len(useUniq) > 0 && useUniq[0] == 'N' {
+		return name, nil
+	}
+
+	return uniqName, nil
+}
+
+// addThread adds curThread to the group in the position given by the group's
+// sort mode and returns its thread number.
 func (thrGrp *GptCliThreadGroup) addThread(curThread *GptCliThread) int {
 	thrGrp.totThreads++
 	thrGrp.threads = append(thrGrp.threads, curThread)
+	thrGrp.resort()
 
-	return thrGrp.totThreads
+	return slices.Index(thrGrp.threads, curThread) + 1
 }
 
 func archiveThreadMain(ctx context.Context, gptCliCtx *GptCliContext,
@@ -375,6 +708,149 @@ func unarchiveThreadMain(ctx context.Context, gptCliCtx *GptCliContext,
 	return lsThreadsMain(ctx, gptCliCtx, lsArgs)
 }
 
+func deleteThreadMain(ctx context.Context, gptCliCtx *GptCliContext,
+	args []string) error {
+
+	if len(args) != 2 {
+		return fmt.Errorf("Syntax is 'delete <thread#>' e.g. 'delete 1'\n")
+	}
+	thrGrp, threadNum, err := parseThreadNum(gptCliCtx, args[1])
+	if err != nil {
+		return err
+	}
+	if threadNum > thrGrp.totThreads || threadNum == 0 {
+		return fmt.Errorf(ThreadNoExistErrFmt, args[1])
+	}
+	if thrGrp == gptCliCtx.curThreadGroup && threadNum == thrGrp.curThreadNum {
+		return fmt.Errorf("Cannot delete the current thread; use 'exit' to leave it first.\n")
+	}
+	thread := thrGrp.threads[threadNum-1]
+
+	fmt.Printf("Permanently delete thread %v %q? This cannot be undone. (Y/N) [N]: ",
+		args[1], thread.Name)
+	confirm, err := gptCliCtx.input.ReadString('\n')
+	if err != nil {
+		return err
+	}
+	confirm = strings.ToUpper(strings.TrimSpace(confirm))
+	if len(confirm) == 0 || confirm[0] != 'Y' {
+		fmt.Printf("gptcli: Thread not deleted.\n")
+		return nil
+	}
+
+	var curFileName string
+	if thrGrp.curThreadNum != 0 {
+		curFileName = thrGrp.threads[thrGrp.curThreadNum-1].fileName
+	}
+	err = thread.remove(thrGrp.dir)
+	if err != nil {
+		return err
+	}
+	err = thrGrp.loadThreads()
+	if err != nil {
+		return err
+	}
+	// reloading deselects the current thread; reselect it if there was one
+	if curFileName != "" {
+		for idx, t := range thrGrp.threads {
+			if t.fileName == curFileName {
+				thrGrp.curThreadNum = idx + 1
+				break
+			}
+		}
+	}
+
+	fmt.Printf("gptcli: Deleted thread %v. Remaining threads renumbered.\n",
+		args[1])
+
+	if gptCliCtx.curThreadGroup.curThreadNum != 0 {
+		return nil
+	}
+	lsArgs := []string{"ls"}
+	return lsThreadsMain(ctx, gptCliCtx, lsArgs)
+}
+
+func renameThreadMain(ctx context.Context, gptCliCtx *GptCliContext,
+	args []string) error {
+
+	if len(args) < 3 {
+		return fmt.Errorf("Syntax is 'rename <thread#> <new name>' e.g. 'rename 1 my thread'\n")
+	}
+	thrGrp, threadNum, err := parseThreadNum(gptCliCtx, args[1])
+	if err != nil {
+		return err
+	}
+	if threadNum > thrGrp.totThreads || threadNum == 0 {
+		return fmt.Errorf(ThreadNoExistErrFmt, args[1])
+	}
+	newName := strings.TrimSpace(strings.Join(args[2:], " "))
+	if newName == "" {
+		return fmt.Errorf("A thread's name cannot be empty\n")
+	}
+	thread := thrGrp.threads[threadNum-1]
+	if newName == thread.Name {
+		return nil
+	}
+	newName, err = confirmThreadName(gptCliCtx, thrGrp, newName)
+	if err != nil {
+		return err
+	}
+
+	oldName := thread.Name
+	err = thrGrp.renameThread(thread, newName)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("gptcli: Renamed thread %v from %q to %q.\n", args[1], oldName,
+		newName)
+
+	return nil
+}
+
+// renameThread changes thread's name to newName, moving its file within the
+// group's directory to match.
+func (thrGrp *GptCliThreadGroup) renameThread(thread *GptCliThread,
+	newName string) error {
+
+	newFileName := genUniqFileName(newName, thread.CreateTime)
+	if newFileName != thread.fileName {
+		// file names are derived from the name and creation time and are
+		// regenerated on load, so a colliding file cannot be worked around
+		_, err := os.Stat(filepath.Join(thrGrp.dir, newFileName))
+		if err == nil {
+			return fmt.Errorf("Cannot rename thread %q to %q: %v already exists\n",
+				thread.Name, newName, filepath.Join(thrGrp.dir, newFileName))
+		} else if !os.IsNotExist(err) {
+			return fmt.Errorf("Could not stat %v: %w",
+				filepath.Join(thrGrp.dir, newFileName), err)
+		}
+	}
+
+	oldName := thread.Name
+	oldFileName := thread.fileName
+	oldModTime := thread.ModTime
+	thread.Name = newName
+	thread.fileName = newFileName
+	thread.ModTime = time.Now()
+	err := thread.save(thrGrp.dir)
+	if err != nil {
+		thread.Name = oldName
+		thread.fileName = oldFileName
+		thread.ModTime = oldModTime
+		return err
+	}
+	if oldFileName != newFileName {
+		err = os.Remove(filepath.Join(thrGrp.dir, oldFileName))
+		if err != nil && !os.IsNotExist(err) {
+			return fmt.Errorf("Failed to remove %v: %w",
+				filepath.Join(thrGrp.dir, oldFileName), err)
+		}
+	}
+	thrGrp.resort()
+
+	return nil
+}
+
 func (srcThrGrp *GptCliThreadGroup) moveThread(threadNum int,
 	dstThrGrp *GptCliThreadGroup) error {
 
@@ -404,16 +880,75 @@ func (srcThrGrp *GptCliThreadGroup) moveThread(threadNum int,
 func interactiveThreadWork(ctx context.Context,
 	gptCliCtx *GptCliContext, prompt string) error {
 
-	msg := openai.ChatCompletionMessage{
-		Role:    openai.ChatMessageRoleUser,
-		Content: prompt,
-	}
-
 	thrGrp := gptCliCtx.curThreadGroup
 	if thrGrp == gptCliCtx.archiveThreadGroup {
 		return fmt.Errorf("Cannot edit archived thread; use unarchive first")
 	}
+
+	shouldSend, err := checkBudget(gptCliCtx)
+	if err != nil {
+		return err
+	} else if !shouldSend {
+		fmt.Printf("gptcli: Prompt not sent.\n")
+		return nil
+	}
+	prompt, shouldSend, err = checkPromptSize(gptCliCtx, prompt)
+	if err != nil {
+		return err
+	} else if !shouldSend {
+		fmt.Printf("gptcli: Prompt not sent.\n")
+		return nil
+	}
+
 	thread := thrGrp.threads[thrGrp.curThreadNum-1]
+	isFirstPrompt := !hasUserMsg(thread.Dialogue)
+	_, err = sendPrompt(ctx, gptCliCtx, thread, thrGrp.dir, prompt,
+		func(ctx context.Context, gptCliCtx *GptCliContext,
+			req openai.ChatCompletionRequest) (string, error) {
+
+			logInfo("gptcli: processing...\n")
+			if gptCliCtx.streamOutput {
+				return streamChat(ctx, gptCliCtx, req, os.Stdout)
+			}
+			return completeChat(ctx, gptCliCtx, req)
+		})
+	if err != nil {
+		return err
+	}
+
+	if gptCliCtx.prefs.AutoName && isFirstPrompt && thread.Name == "" {
+		return thrGrp.autoNameThread(thread, prompt)
+	}
+
+	return nil
+}
+
+// hasUserMsg returns true if dialogue contains any prompts from the user.
+func hasUserMsg(dialogue []openai.ChatCompletionMessage) bool {
+	for _, msg := range dialogue {
+		if msg.Role == openai.ChatMessageRoleUser {
+			return true
+		}
+	}
+
+	return false
+}
+
+// chatFunc sends a chat completion request to OpenAI and returns the reply.
+type chatFunc func(ctx context.Context, gptCliCtx *GptCliContext,
+	req openai.ChatCompletionRequest) (string, error)
+
+// sendPrompt adds prompt to thread's dialogue, sends the dialogue (or its
+// summary) to OpenAI via chat, appends the reply and saves the thread to
+// dir. It returns the reply.
+func sendPrompt(ctx context.Context, gptCliCtx *GptCliContext,
+	thread *GptCliThread, dir string, prompt string,
+	chat chatFunc) (string, error) {
+
+	msg := openai.ChatCompletionMessage{
+		Role:    openai.ChatMessageRoleUser,
+		Content: prompt,
+	}
 	dialogue := thread.Dialogue
 	summaryDialogue := dialogue
 
@@ -427,44 +962,30 @@ func interactiveThreadWork(ctx context.Context,
 		}
 		summaryDialogue, err = summarizeDialogue(ctx, gptCliCtx, summaryDialogue)
 		if err != nil {
-			return err
+			return "", err
 		}
 		summaryDialogue = append(summaryDialogue, msg)
 		dialogue2Send = summaryDialogue
 	}
 
-	fmt.Printf("gptcli: processing...\n")
-
-	resp, err := gptCliCtx.client.CreateChatCompletion(ctx,
-		openai.ChatCompletionRequest{
-			Model:    openai.GPT4o,
-			Messages: dialogue2Send,
-		},
-	)
+	dialogue2Send, err = expandSystemMsgs(dialogue2Send)
 	if err != nil {
-		return err
+		return "", err
 	}
+	dialogue2Send = applyUserPreamble(dialogue2Send, &gptCliCtx.prefs)
 
-	if len(resp.Choices) != 1 {
-		return fmt.Errorf("gptcli: BUG: Expected 1 response, got %v",
-			len(resp.Choices))
+	req := openai.ChatCompletionRequest{
+		Model:    gptCliCtx.prefs.model(),
+		Messages: dialogue2Send,
 	}
-
-	var sb strings.Builder
-	blocks := splitBlocks(resp.Choices[0].Message.Content)
-	for idx, b := range blocks {
-		if idx%2 == 0 {
-			sb.WriteString(color.CyanString("%v\n", b))
-		} else {
-			sb.WriteString(color.GreenString("%v\n", b))
-		}
+	replyContent, err := chat(ctx, gptCliCtx, req)
+	if err != nil {
+		return "", err
 	}
 
-	printToScreen(sb.String())
-
 	msg = openai.ChatCompletionMessage{
 		Role:    openai.ChatMessageRoleAssistant,
-		Content: resp.Choices[0].Message.Content,
+		Content: replyContent,
 	}
 	thread.Dialogue = append(dialogue, msg)
 	thread.ModTime = time.Now()
@@ -473,12 +994,106 @@ func interactiveThreadWork(ctx context.Context,
 		thread.SummaryDialogue = append(summaryDialogue, msg)
 	}
 
-	err = thread.save(thrGrp.dir)
+	err = thread.save(dir)
 	if err != nil {
-		return err
+		return "", err
 	}
 
-	return nil
+	return replyContent, nil
+}
+
+// checkPromptSize asks the user to confirm sending a prompt larger than the
+// configured maximum, offering to truncate it instead. It returns the prompt
+// to send and whether it should be sent at all.
+func checkPromptSize(gptCliCtx *GptCliContext, prompt string) (string, bool,
+	error) {
+
+	maxBytes := gptCliCtx.prefs.MaxPromptBytes
+	if maxBytes <= 0 || len(prompt) <= maxBytes {
+		return prompt, true, nil
+	}
+
+	fmt.Printf("Prompt is %v bytes which exceeds the maximum of %v bytes. Send, truncate, or cancel? (S/T/C) [C]: ",
+		len(prompt), maxBytes)
+	answer, err := gptCliCtx.input.ReadString('\n')
+	if err != nil {
+		return "", false, err
+	}
+	answer = strings.ToUpper(strings.TrimSpace(answer))
+	if len(answer) == 0 {
+		answer = "C"
+	}
+
+	switch answer[0] {
+	case 'S':
+		return prompt, true, nil
+	case 'T':
+		return truncateUTF8(prompt, maxBytes), true, nil
+	}
+
+	return "", false, nil
+}
+
+// truncateUTF8 shortens s to at most maxBytes bytes without splitting a
+// multibyte character.
+func truncateUTF8(s string, maxBytes int) string {
+	if len(s) <= maxBytes {
+		return s
+	}
+	for maxBytes > 0 && !utf8.RuneStart(s[maxBytes]) {
+		maxBytes--
+	}
+
+	return s[:maxBytes]
+}
+
+// requestChat sends req to OpenAI without streaming and returns the reply.
+func requestChat(ctx context.Context, gptCliCtx *GptCliContext,
+	req openai.ChatCompletionRequest) (string, error) {
+
+	resp, err := gptCliCtx.client.CreateChatCompletion(ctx, req)
+	if err != nil {
+		return "", err
+	}
+	gptCliCtx.accrueUsage(resp.Usage)
+
+	if len(resp.Choices) != 1 {
+		return "", fmt.Errorf("gptcli: BUG: Expected 1 response, got %v",
+			len(resp.Choices))
+	}
+
+	return resp.Choices[0].Message.Content, nil
+}
+
+// completeChat sends req and displays the full reply once it has been
+// received.
+func completeChat(ctx context.Context, gptCliCtx *GptCliContext,
+	req openai.ChatCompletionRequest) (string, error) {
+
+	reply, err := requestChat(ctx, gptCliCtx, req)
+	if err != nil {
+		return "", err
+	}
+
+	printToScreen(formatReply(reply))
+
+	return reply, nil
+}
+
+// formatReply colorizes an assistant reply for display, distinguishing code
+// blocks from the surrounding text.
+func formatReply(reply string) string {
+	var sb strings.Builder
+	blocks := splitBlocks(reply)
+	for idx, b := range blocks {
+		if idx%2 == 0 {
+			sb.WriteString(color.CyanString("%v\n", b))
+		} else {
+			sb.WriteString(color.GreenString("%v\n", b))
+		}
+	}
+
+	return sb.String()
 }
 
 func catMain(ctx context.Context, gptCliCtx *GptCliContext,
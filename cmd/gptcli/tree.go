@@ -0,0 +1,89 @@
+/* Copyright © 2024 Mike Brown. All Rights Reserved.
+ *
+ * See LICENSE file at the root of this package for license terms
+ */
+package main
+
+import (
+	"strings"
+	"time"
+)
+
+const (
+	threadTreeBranch      = "└─ "
+	threadTreeASCIIBranch = "`- "
+	threadTreeIndent      = "   "
+)
+
+// id returns an identifier for thread which, unlike its name and file name,
+// remains stable across renames and archiving. It is used to link threads
+// that were cloned to their parent.
+func (thread *GptCliThread) id() string {
+	return thread.CreateTime.UTC().Format(time.RFC3339Nano)
+}
+
+// threadTreeNode is a thread's position when a group's threads are
+// displayed as a tree: the thread's index within the group and its depth
+// beneath its root ancestor.
+type threadTreeNode struct {
+	idx   int
+	depth int
+}
+
+// threadTreeOrder arranges threads so that each thread is immediately
+// followed by its descendants. Threads whose parent isn't amongst threads are
+// treated as roots. Roots and siblings retain their relative order.
+func threadTreeOrder(threads []*GptCliThread) []threadTreeNode {
+	idxByID := make(map[string]int, len(threads))
+	for idx, t := range threads {
+		idxByID[t.id()] = idx
+	}
+	children := make(map[int][]int)
+	roots := make([]int, 0)
+	for idx, t := range threads {
+		parentIdx, ok := idxByID[t.Parent]
+		if t.Parent == "" || !ok || parentIdx == idx {
+			roots = append(roots, idx)
+			continue
+		}
+		children[parentIdx] = append(children[parentIdx], idx)
+	}
+
+	nodes := make([]threadTreeNode, 0, len(threads))
+	visited := make(map[int]bool, len(threads))
+	var visit func(idx int, depth int)
+	visit = func(idx int, depth int) {
+		if visited[idx] {
+			return
+		}
+		visited[idx] = true
+		nodes = append(nodes, threadTreeNode{idx: idx, depth: depth})
+		for _, childIdx := range children[idx] {
+			visit(childIdx, depth+1)
+		}
+	}
+	for _, idx := range roots {
+		visit(idx, 0)
+	}
+	// threads within a parent cycle are unreachable from any root; list
+	// them at the top level rather than hiding them
+	for idx := range threads {
+		visit(idx, 0)
+	}
+
+	return nodes
+}
+
+// treePrefix returns the indentation marking a thread at depth within a
+// tree listing.
+func (tbl *threadTable) treePrefix(depth int) string {
+	if depth == 0 {
+		return ""
+	}
+	branch := threadTreeBranch
+	if tbl.ascii {
+		branch = threadTreeASCIIBranch
+	}
+
+	return strings.Repeat(threadTreeIndent, depth-1) + branch
+}
@@ -32,7 +32,7 @@ func versionMain(ctx context.Context, gptCliCtx *GptCliContext, args []string) e
 
 func upgradeMain(ctx context.Context, gptCliCtx *GptCliContext, args []string) error {
 	if versionText == DevVersionText {
-		fmt.Fprintf(os.Stderr, "Skipping gptcli upgrade on development version\n")
+		logInfo("Skipping gptcli upgrade on development version\n")
 		return nil
 	}
 	latestVer, err := getLatestVersion()
@@ -181,7 +181,7 @@ func checkAndPrintUpgradeWarning() bool {
 		return false
 	}
 
-	fmt.Fprintf(os.Stderr, "*WARN*: A new version of gptcli is available (%v). Please upgrade via 'upgrade'.\n\n",
+	logInfo("gptcli: A new version of gptcli is available (%v). Please upgrade via 'upgrade'.\n\n",
 		latestVer)
 
 	return true